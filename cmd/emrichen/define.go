@@ -0,0 +1,302 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/go-go-golems/go-emrichen/pkg/emrichen"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// A define sets a variable from the command line, with -D path=value. Each
+// -D flag sets a single variable, commas being part of the value.
+//
+// The value is parsed as a YAML scalar or flow collection, so that
+// `-D replicas=3` defines an integer and `-D ports=[80,443]` a list. Values
+// which are not (such as block mappings or custom tags) are kept as strings,
+// and `-D path:=value` always defines a string.
+//
+// The path is a variable name, optionally followed by keys and indices
+// setting a nested value: `-D image.tag=v2` or `-D ports[0]=8080`. Mappings
+// and lists along the path are created if needed, and values loaded from var
+// files are updated rather than replaced.
+type define struct {
+	path  []pathSegment
+	value *yaml.Node
+}
+
+// pathSegment is a mapping key, or an index if isIndex is set.
+type pathSegment struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// defineFlagValue replaces the value of the --define flag, which glazed
+// declares as a string slice splitting its arguments on commas. It keeps each
+// argument whole instead, so that `-D ports=[80,443]` or
+// `-D selector=app=web,tier=db` define a single variable. The values are
+// still read back by glazed as a string slice.
+type defineFlagValue struct {
+	sliceValue
+	changed bool
+}
+
+// sliceValue is implemented by the string slice flag values of pflag.
+type sliceValue interface {
+	String() string
+	Set(string) error
+	Type() string
+	Append(string) error
+	Replace([]string) error
+}
+
+func (v *defineFlagValue) Set(arg string) error {
+	if !v.changed {
+		v.changed = true
+		return v.Replace([]string{arg})
+	}
+	return v.Append(arg)
+}
+
+// keepDefineCommas installs defineFlagValue on the --define flag of cmd.
+func keepDefineCommas(cmd *cobra.Command) error {
+	flag := cmd.Flags().Lookup("define")
+	if flag == nil {
+		return errors.New("no define flag")
+	}
+	value, ok := flag.Value.(sliceValue)
+	if !ok || value.Type() != "stringSlice" {
+		return errors.Errorf("unexpected type %s of the define flag", flag.Value.Type())
+	}
+	flag.Value = &defineFlagValue{sliceValue: value}
+	return nil
+}
+
+func parseDefine(arg string) (*define, error) {
+	key, value, ok := strings.Cut(arg, "=")
+	if !ok {
+		return nil, errors.Errorf("could not parse define %s, expected path=value", arg)
+	}
+
+	raw := strings.HasSuffix(key, ":")
+	key = strings.TrimSuffix(key, ":")
+	path, err := parsePath(key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not parse define %s", arg)
+	}
+
+	ret := &define{path: path}
+	if raw {
+		ret.value = &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+	} else {
+		ret.value = parseDefineValue(value)
+	}
+	return ret, nil
+}
+
+// parseDefineValue parses value as a YAML scalar or flow collection, and
+// falls back to a string.
+func parseDefineValue(value string) *yaml.Node {
+	str := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+	if strings.TrimSpace(value) == "" {
+		return str
+	}
+
+	var document yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &document); err != nil {
+		return str
+	}
+	if document.Kind != yaml.DocumentNode || len(document.Content) != 1 {
+		return str
+	}
+	node := document.Content[0]
+	if node.Kind != yaml.ScalarNode && node.Style&yaml.FlowStyle == 0 {
+		return str
+	}
+	if !hasStandardTags(node) {
+		return str
+	}
+	return node
+}
+
+// hasStandardTags returns false if node contains custom tags, such as
+// emrichen tags, which would not be evaluated in a variable.
+func hasStandardTags(node *yaml.Node) bool {
+	if node.Kind == yaml.AliasNode {
+		return false
+	}
+	if strings.HasPrefix(node.Tag, "!") && !strings.HasPrefix(node.Tag, "!!") {
+		return false
+	}
+	for _, child := range node.Content {
+		if !hasStandardTags(child) {
+			return false
+		}
+	}
+	return true
+}
+
+// parsePath parses a variable name followed by `.key` and `[index]`
+// segments.
+func parsePath(s string) ([]pathSegment, error) {
+	var ret []pathSegment
+	rest := s
+	for rest != "" {
+		switch {
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, errors.Errorf("unterminated index in %s", s)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, errors.Errorf("invalid index %s in %s", rest[1:end], s)
+			}
+			if len(ret) == 0 {
+				return nil, errors.Errorf("%s must start with a variable name", s)
+			}
+			ret = append(ret, pathSegment{index: index, isIndex: true})
+			rest = rest[end+1:]
+		default:
+			if rest[0] == '.' {
+				if len(ret) == 0 {
+					return nil, errors.Errorf("%s must start with a variable name", s)
+				}
+				rest = rest[1:]
+			} else if len(ret) > 0 {
+				return nil, errors.Errorf("expected . or [ in %s", s)
+			}
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, errors.Errorf("empty key in %s", s)
+			}
+			ret = append(ret, pathSegment{key: rest[:end]})
+			rest = rest[end:]
+		}
+	}
+	if len(ret) == 0 {
+		return nil, errors.New("empty variable name")
+	}
+	return ret, nil
+}
+
+func formatPath(path []pathSegment) string {
+	var sb strings.Builder
+	for i, segment := range path {
+		switch {
+		case segment.isIndex:
+			sb.WriteString("[" + strconv.Itoa(segment.index) + "]")
+		case i > 0:
+			sb.WriteString("." + segment.key)
+		default:
+			sb.WriteString(segment.key)
+		}
+	}
+	return sb.String()
+}
+
+// applyDefine sets the value of d in vars.
+func applyDefine(vars map[string]interface{}, d *define) error {
+	name := d.path[0].key
+	if len(d.path) == 1 {
+		vars[name] = d.value
+		return nil
+	}
+
+	var root *yaml.Node
+	if existing, ok := vars[name]; ok {
+		var err error
+		root, err = emrichen.ValueToNode(existing)
+		if err != nil {
+			return err
+		}
+	}
+	root, err := setNodePath(root, d.path, 1, d.value)
+	if err != nil {
+		return err
+	}
+	vars[name] = root
+	return nil
+}
+
+// setNodePath returns a copy of node with the value at path[i:] set to value.
+// A missing or null node is replaced by a mapping or list.
+func setNodePath(node *yaml.Node, path []pathSegment, i int, value *yaml.Node) (*yaml.Node, error) {
+	if i == len(path) {
+		return value, nil
+	}
+	if node != nil && node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	isNull := node == nil || (node.Kind == yaml.ScalarNode && node.Tag == "!!null")
+
+	segment := path[i]
+	if segment.isIndex {
+		var ret *yaml.Node
+		switch {
+		case isNull:
+			ret = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		case node.Kind == yaml.SequenceNode:
+			ret = copyNode(node)
+		default:
+			return nil, errors.Errorf("cannot set %s: %s is not a list", formatPath(path), formatPath(path[:i]))
+		}
+		if segment.index > len(ret.Content) {
+			return nil, errors.Errorf("cannot set %s: index out of range, %s has %d items", formatPath(path), formatPath(path[:i]), len(ret.Content))
+		}
+		var child *yaml.Node
+		if segment.index < len(ret.Content) {
+			child = ret.Content[segment.index]
+		}
+		child, err := setNodePath(child, path, i+1, value)
+		if err != nil {
+			return nil, err
+		}
+		if segment.index == len(ret.Content) {
+			ret.Content = append(ret.Content, child)
+		} else {
+			ret.Content[segment.index] = child
+		}
+		return ret, nil
+	}
+
+	var ret *yaml.Node
+	switch {
+	case isNull:
+		ret = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	case node.Kind == yaml.MappingNode:
+		ret = copyNode(node)
+	default:
+		return nil, errors.Errorf("cannot set %s: %s is not a mapping", formatPath(path), formatPath(path[:i]))
+	}
+	for j := 0; j+1 < len(ret.Content); j += 2 {
+		if ret.Content[j].Value == segment.key {
+			child, err := setNodePath(ret.Content[j+1], path, i+1, value)
+			if err != nil {
+				return nil, err
+			}
+			ret.Content[j+1] = child
+			return ret, nil
+		}
+	}
+	child, err := setNodePath(nil, path, i+1, value)
+	if err != nil {
+		return nil, err
+	}
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: segment.key}
+	ret.Content = append(ret.Content, key, child)
+	return ret, nil
+}
+
+// copyNode returns a shallow copy of node, with its own list of children.
+func copyNode(node *yaml.Node) *yaml.Node {
+	ret := *node
+	ret.Content = append([]*yaml.Node(nil), node.Content...)
+	return &ret
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestKeepDefineCommas(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected []string
+	}{
+		{
+			name:     "Separate Flags",
+			args:     []string{"-D", "a=1", "--define", "b=2"},
+			expected: []string{"a=1", "b=2"},
+		},
+		{
+			name:     "Comma In Value",
+			args:     []string{"-D", "selector=app=web,tier=db"},
+			expected: []string{"selector=app=web,tier=db"},
+		},
+		{
+			name:     "Flow List",
+			args:     []string{"-D", "ports=[80,443]", "-D", "greeting=hello, world"},
+			expected: []string{"ports=[80,443]", "greeting=hello, world"},
+		},
+		{
+			name:     "Quotes",
+			args:     []string{"-D", `name="a,b"`},
+			expected: []string{`name="a,b"`},
+		},
+		{
+			name:     "No Flags",
+			expected: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := &cobra.Command{Use: "test"}
+			cmd.Flags().StringSliceP("define", "D", nil, "")
+			require.NoError(t, keepDefineCommas(cmd))
+
+			require.NoError(t, cmd.ParseFlags(tt.args))
+			defines, err := cmd.Flags().GetStringSlice("define")
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, defines)
+		})
+	}
+}
+
+func TestParseDefine(t *testing.T) {
+	tests := []struct {
+		name     string
+		arg      string
+		path     string
+		expected string
+		isString bool
+		err      string
+	}{
+		{name: "Integer", arg: "replicas=3", path: "replicas", expected: "3"},
+		{name: "Raw String", arg: "replicas:=3", path: "replicas", expected: `"3"`, isString: true},
+		{name: "Boolean", arg: "debug=true", path: "debug", expected: "true"},
+		{name: "Flow List", arg: "ports=[80, 443]", path: "ports", expected: "[80, 443]"},
+		{name: "Flow Mapping", arg: "image={repo: nginx}", path: "image", expected: "{repo: nginx}"},
+		{name: "Equals In Value", arg: "selector=app=web", path: "selector", expected: "app=web", isString: true},
+		{name: "Empty Value", arg: "name=", path: "name", expected: `""`, isString: true},
+		{name: "Custom Tag", arg: "name=!Var x", path: "name", expected: "'!Var x'", isString: true},
+		{name: "Invalid YAML", arg: "name=[a", path: "name", expected: "'[a'", isString: true},
+		{name: "Nested Path", arg: "image.tag=v2", path: "image.tag", expected: "v2", isString: true},
+		{name: "Indexed Path", arg: "ports[1]=8080", path: "ports[1]", expected: "8080"},
+		{name: "Missing Value", arg: "name", err: "could not parse define name, expected path=value"},
+		{name: "Invalid Path", arg: "a..b=1", err: "could not parse define a..b=1: empty key in a..b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := parseDefine(tt.arg)
+			if tt.err != "" {
+				require.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.path, formatPath(d.path))
+			assert.Equal(t, tt.expected, marshalFlow(t, d.value))
+			assert.Equal(t, tt.isString, d.value.ShortTag() == "!!str")
+		})
+	}
+}
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected []pathSegment
+		err      string
+	}{
+		{path: "name", expected: []pathSegment{{key: "name"}}},
+		{path: "image.tag", expected: []pathSegment{{key: "image"}, {key: "tag"}}},
+		{
+			path:     "spec.ports[0].port",
+			expected: []pathSegment{{key: "spec"}, {key: "ports"}, {index: 0, isIndex: true}, {key: "port"}},
+		},
+		{path: "matrix[1][2]", expected: []pathSegment{{key: "matrix"}, {index: 1, isIndex: true}, {index: 2, isIndex: true}}},
+		{path: "", err: "empty variable name"},
+		{path: "[0]", err: "[0] must start with a variable name"},
+		{path: ".a", err: ".a must start with a variable name"},
+		{path: "a[", err: "unterminated index in a["},
+		{path: "a[x]", err: "invalid index x in a[x]"},
+		{path: "a[-1]", err: "invalid index -1 in a[-1]"},
+		{path: "a[0]b", err: "expected . or [ in a[0]b"},
+		{path: "a.", err: "empty key in a."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			path, err := parsePath(tt.path)
+			if tt.err != "" {
+				require.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, path)
+			assert.Equal(t, tt.path, formatPath(path))
+		})
+	}
+}
+
+func TestSetNodePath(t *testing.T) {
+	tests := []struct {
+		name     string
+		node     string
+		path     string
+		value    string
+		expected string
+		err      string
+	}{
+		{name: "Missing Node", path: "a.b.c", value: "1", expected: "{b: {c: 1}}"},
+		{name: "Null Node", node: "null", path: "a.b", value: "1", expected: "{b: 1}"},
+		{name: "Missing List", path: "a[0].name", value: "x", expected: "[{name: x}]"},
+		{name: "Update Key", node: "{b: 1, c: 2}", path: "a.b", value: "3", expected: "{b: 3, c: 2}"},
+		{name: "Add Key", node: "{b: 1}", path: "a.c", value: "2", expected: "{b: 1, c: 2}"},
+		{name: "Nested Update", node: "{b: {c: 1, d: 2}}", path: "a.b.c", value: "3", expected: "{b: {c: 3, d: 2}}"},
+		{name: "Update Item", node: "[1, 2]", path: "a[1]", value: "3", expected: "[1, 3]"},
+		{name: "Append Item", node: "[1, 2]", path: "a[2]", value: "3", expected: "[1, 2, 3]"},
+		{name: "Key In Item", node: "[{name: x, port: 80}]", path: "a[0].port", value: "8080", expected: "[{name: x, port: 8080}]"},
+		{
+			name: "Index Out Of Range", node: "[1]", path: "a[2]", value: "3",
+			err: "cannot set a[2]: index out of range, a has 1 items",
+		},
+		{name: "Not A List", node: "{b: 1}", path: "a[0]", value: "1", err: "cannot set a[0]: a is not a list"},
+		{name: "Not A Mapping", node: "{b: 1}", path: "a.b.c", value: "1", err: "cannot set a.b.c: a.b is not a mapping"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var node *yaml.Node
+			var original string
+			if tt.node != "" {
+				node = parseYAML(t, tt.node)
+				original = marshalFlow(t, node)
+			}
+			path, err := parsePath(tt.path)
+			require.NoError(t, err)
+
+			ret, err := setNodePath(node, path, 1, parseYAML(t, tt.value))
+			if tt.err != "" {
+				require.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, marshalFlow(t, ret))
+			if node != nil {
+				// the original node is not modified
+				assert.Equal(t, original, marshalFlow(t, node))
+			}
+		})
+	}
+}
+
+func TestApplyDefine(t *testing.T) {
+	vars := map[string]interface{}{
+		"image": map[string]interface{}{"repo": "nginx", "tag": "v1"},
+	}
+	for _, arg := range []string{"image.tag=v2", "ports=[80]", "ports[1]=443", "name:=3"} {
+		d, err := parseDefine(arg)
+		require.NoError(t, err)
+		require.NoError(t, applyDefine(vars, d))
+	}
+
+	assert.Equal(t, "{repo: nginx, tag: v2}", marshalFlow(t, vars["image"].(*yaml.Node)))
+	assert.Equal(t, "[80, 443]", marshalFlow(t, vars["ports"].(*yaml.Node)))
+	assert.Equal(t, `"3"`, marshalFlow(t, vars["name"].(*yaml.Node)))
+}
+
+func parseYAML(t *testing.T, s string) *yaml.Node {
+	var document yaml.Node
+	require.NoError(t, yaml.Unmarshal([]byte(s), &document))
+	return document.Content[0]
+}
+
+// marshalFlow marshals node on a single line.
+func marshalFlow(t *testing.T, node *yaml.Node) string {
+	flow := *node
+	flow.Style |= yaml.FlowStyle
+	if flow.Kind == yaml.ScalarNode {
+		flow.Style = node.Style
+	}
+	out, err := yaml.Marshal(&flow)
+	require.NoError(t, err)
+	return string(out[:len(out)-1])
+}
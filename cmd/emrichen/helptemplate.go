@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/go-go-golems/go-emrichen/pkg/emrichen"
+	"gopkg.in/yaml.v3"
+)
+
+// printTemplateHelp lists the parameters a template declares with !Params.
+func printTemplateHelp(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func(f io.Closer) {
+		_ = f.Close()
+	}(f)
+
+	template, err := emrichen.Compile(f)
+	if err != nil {
+		return err
+	}
+
+	params := template.Params()
+	if len(params) == 0 {
+		_, err = fmt.Fprintf(w, "%s declares no parameters.\n", path)
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "Parameters of %s (set with -D name=value):\n\n", path)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	_, err = fmt.Fprintln(tw, "NAME\tTYPE\tREQUIRED\tDEFAULT\tDESCRIPTION")
+	if err != nil {
+		return err
+	}
+	for _, param := range params {
+		required := "no"
+		if param.Required {
+			required = "yes"
+		}
+		description := param.Description
+		if len(param.Enum) > 0 {
+			allowed := make([]string, 0, len(param.Enum))
+			for _, e := range param.Enum {
+				allowed = append(allowed, formatNode(e))
+			}
+			description = strings.TrimSpace(description + " (one of " + strings.Join(allowed, ", ") + ")")
+		}
+		_, err = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+			param.Name, param.Type, required, formatNode(param.Default), description)
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+// formatNode formats an unevaluated value on a single line, or returns "-"
+// for nil.
+func formatNode(node *yaml.Node) string {
+	if node == nil {
+		return "-"
+	}
+	flow := *node
+	if flow.Kind == yaml.SequenceNode || flow.Kind == yaml.MappingNode {
+		flow.Style = yaml.FlowStyle
+	}
+	out, err := yaml.Marshal(&flow)
+	if err != nil {
+		return node.Value
+	}
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrintTemplateHelp(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{
+			name: "Parameters",
+			template: `!Params
+env:
+  type: string
+  enum: [dev, prod]
+  required: true
+  description: Target environment
+ports:
+  type: list
+  default: [80, 443]
+name:
+  default: !Format "app-{env}"
+debug: bool
+---
+env: !Var env
+`,
+			expected: `Parameters of TEMPLATE (set with -D name=value):
+
+NAME   TYPE    REQUIRED  DEFAULT              DESCRIPTION
+env    string  yes       -                    Target environment (one of dev, prod)
+ports  list    no        [80, 443]
+name   any     no        !Format "app-{env}"
+debug  bool    no        -
+`,
+		},
+		{
+			name:     "No Parameters",
+			template: "a: 1\n",
+			expected: "TEMPLATE declares no parameters.\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "template.yaml")
+			require.NoError(t, os.WriteFile(path, []byte(tt.template), 0o644))
+
+			var buf bytes.Buffer
+			require.NoError(t, printTemplateHelp(&buf, path))
+			// the last column is padded with trailing spaces
+			lines := strings.Split(strings.ReplaceAll(buf.String(), path, "TEMPLATE"), "\n")
+			for i, line := range lines {
+				lines[i] = strings.TrimRight(line, " ")
+			}
+			assert.Equal(t, tt.expected, strings.Join(lines, "\n"))
+		})
+	}
+}
@@ -2,19 +2,20 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"github.com/Masterminds/sprig"
 	"github.com/go-go-golems/glazed/pkg/cli"
 	"github.com/go-go-golems/glazed/pkg/cmds"
 	"github.com/go-go-golems/glazed/pkg/cmds/layers"
 	"github.com/go-go-golems/glazed/pkg/cmds/parameters"
 	"github.com/go-go-golems/glazed/pkg/help"
-	"github.com/go-go-golems/glazed/pkg/helpers/cast"
 	"github.com/go-go-golems/go-emrichen/pkg/doc"
 	"github.com/go-go-golems/go-emrichen/pkg/emrichen"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"io"
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -30,8 +31,31 @@ type ProcessSettings struct {
 	VarFile      []*parameters.FileData `glazed.parameter:"var-file"`
 	Output       string                 `glazed.parameter:"output"`
 	OutputFormat string                 `glazed.parameter:"output-format"`
+	JSONArray    bool                   `glazed.parameter:"json-array"`
 	IncludeEnv   bool                   `glazed.parameter:"include-env"`
-	Define       map[string]string      `glazed.parameter:"define"`
+	EnvNamespace string                 `glazed.parameter:"env-namespace"`
+	Define       []string               `glazed.parameter:"define"`
+	Timeout      int                    `glazed.parameter:"timeout"`
+	MaxDepth     int                    `glazed.parameter:"max-depth"`
+	MaxNodes     int                    `glazed.parameter:"max-nodes"`
+	MaxLoop      int                    `glazed.parameter:"max-loop-iterations"`
+	MaxInclude   int                    `glazed.parameter:"max-include-depth"`
+	MaxBytes     int                    `glazed.parameter:"max-include-bytes"`
+	IncludePath  []string               `glazed.parameter:"include-path"`
+	Sandbox      bool                   `glazed.parameter:"sandbox"`
+	IncludeRoot  string                 `glazed.parameter:"include-root"`
+	AllowTags    []string               `glazed.parameter:"allow-tags"`
+	DenyTags     []string               `glazed.parameter:"deny-tags"`
+	// SharedDefaults processes all input files with a single interpreter, so
+	// that !Defaults of a file apply to the files following it.
+	SharedDefaults bool     `glazed.parameter:"shared-defaults"`
+	Prelude        []string `glazed.parameter:"prelude"`
+	// VarFileTemplate are var files containing emrichen tags, rendered after
+	// the var files.
+	VarFileTemplate []string `glazed.parameter:"var-file-template"`
+	// HelpTemplate is a template whose !Params are listed instead of
+	// processing the input files.
+	HelpTemplate string `glazed.parameter:"help-template"`
 }
 
 func NewProcessCommand() (*ProcessCommand, error) {
@@ -44,45 +68,151 @@ func NewProcessCommand() (*ProcessCommand, error) {
 					"input-files",
 					parameters.ParameterTypeFileList,
 					parameters.WithHelp("Input files to process"),
-					parameters.WithRequired(true),
 				),
 			),
 			cmds.WithFlags(
-				parameters.NewParameterDefinition(
-					"var-file",
-					parameters.ParameterTypeFileList,
-					parameters.WithHelp("File list to process"),
-					parameters.WithShortFlag("f"),
-				),
-				parameters.NewParameterDefinition(
-					"output",
-					parameters.ParameterTypeString,
-					parameters.WithHelp("Output file"),
-					parameters.WithShortFlag("o"),
-				),
-				parameters.NewParameterDefinition(
-					"output-format",
-					parameters.ParameterTypeChoice,
-					parameters.WithHelp("Output format (json, yaml, pprint)"),
-					parameters.WithChoices("json", "yaml", "pprint"),
-				),
-				parameters.NewParameterDefinition(
-					"include-env",
-					parameters.ParameterTypeBool,
-					parameters.WithHelp("Include environment variables"),
-					parameters.WithShortFlag("e"),
-				),
-				parameters.NewParameterDefinition(
-					"define",
-					parameters.ParameterTypeKeyValue,
-					parameters.WithHelp("Define key-value variables"),
-					parameters.WithShortFlag("D"),
-				),
+				append([]*parameters.ParameterDefinition{
+					parameters.NewParameterDefinition(
+						"output",
+						parameters.ParameterTypeString,
+						parameters.WithHelp("Output file (written atomically, defaults to stdout)"),
+						parameters.WithShortFlag("o"),
+					),
+					parameters.NewParameterDefinition(
+						"output-format",
+						parameters.ParameterTypeChoice,
+						parameters.WithHelp("Output format (json, yaml, pprint)"),
+						parameters.WithChoices("json", "yaml", "pprint"),
+						parameters.WithDefault("yaml"),
+					),
+					parameters.NewParameterDefinition(
+						"json-array",
+						parameters.ParameterTypeBool,
+						parameters.WithHelp("Emit all documents as a single JSON array instead of one document per line (json and pprint formats)"),
+						parameters.WithDefault(false),
+					),
+					parameters.NewParameterDefinition(
+						"help-template",
+						parameters.ParameterTypeString,
+						parameters.WithHelp("List the parameters declared with !Params by a template, instead of processing the input files"),
+					),
+				}, variableFlags()...)...,
 			),
 		),
 	}, nil
 }
 
+// variableFlags are the flags configuring the interpreter and the variables
+// passed to templates, shared by the process and vars commands.
+func variableFlags() []*parameters.ParameterDefinition {
+	return []*parameters.ParameterDefinition{
+		parameters.NewParameterDefinition(
+			"var-file",
+			parameters.ParameterTypeFileList,
+			parameters.WithHelp("Files defining variables (YAML, JSON, TOML or .env), merged in order"),
+			parameters.WithShortFlag("f"),
+		),
+		parameters.NewParameterDefinition(
+			"var-file-template",
+			parameters.ParameterTypeStringList,
+			parameters.WithHelp("Var files containing emrichen tags, rendered in order with the variables loaded before them and merged after the --var-files"),
+		),
+		parameters.NewParameterDefinition(
+			"include-env",
+			parameters.ParameterTypeBool,
+			parameters.WithHelp("Include environment variables"),
+			parameters.WithShortFlag("e"),
+		),
+		parameters.NewParameterDefinition(
+			"env-namespace",
+			parameters.ParameterTypeString,
+			parameters.WithHelp("Variable under which environment variables are exposed (empty for top level)"),
+			parameters.WithDefault("env"),
+		),
+		parameters.NewParameterDefinition(
+			"define",
+			parameters.ParameterTypeStringList,
+			parameters.WithHelp("Define a variable as path=value, overriding --var-file (the value is parsed as YAML, use path:=value for a string; paths like image.tag or ports[0] set nested values)"),
+			parameters.WithShortFlag("D"),
+		),
+		parameters.NewParameterDefinition(
+			"prelude",
+			parameters.ParameterTypeStringList,
+			parameters.WithHelp("Files processed before the input files, whose !Defaults apply to all inputs (they must not produce output)"),
+		),
+		parameters.NewParameterDefinition(
+			"shared-defaults",
+			parameters.ParameterTypeBool,
+			parameters.WithHelp("Process all input files in a single scope, so that !Defaults of a file apply to the following files"),
+			parameters.WithDefault(false),
+		),
+		parameters.NewParameterDefinition(
+			"include-path",
+			parameters.ParameterTypeStringList,
+			parameters.WithHelp("Directories searched for included files not found relative to the including file"),
+			parameters.WithShortFlag("I"),
+		),
+		parameters.NewParameterDefinition(
+			"sandbox",
+			parameters.ParameterTypeBool,
+			parameters.WithHelp("Process untrusted templates: confine includes to --include-root and deny dangerous template functions"),
+			parameters.WithDefault(false),
+		),
+		parameters.NewParameterDefinition(
+			"include-root",
+			parameters.ParameterTypeString,
+			parameters.WithHelp("Directory included files are confined to in sandbox mode"),
+			parameters.WithDefault("."),
+		),
+		parameters.NewParameterDefinition(
+			"allow-tags",
+			parameters.ParameterTypeStringList,
+			parameters.WithHelp("Only allow these tags in sandbox mode (e.g. !Var,!Loop)"),
+		),
+		parameters.NewParameterDefinition(
+			"deny-tags",
+			parameters.ParameterTypeStringList,
+			parameters.WithHelp("Deny these tags in sandbox mode"),
+		),
+		parameters.NewParameterDefinition(
+			"timeout",
+			parameters.ParameterTypeInteger,
+			parameters.WithHelp("Abort processing after this many seconds (0 for no timeout)"),
+			parameters.WithDefault(0),
+		),
+		parameters.NewParameterDefinition(
+			"max-depth",
+			parameters.ParameterTypeInteger,
+			parameters.WithHelp("Maximum nesting depth of processed nodes (0 for unlimited)"),
+			parameters.WithDefault(emrichen.DefaultLimits.MaxDepth),
+		),
+		parameters.NewParameterDefinition(
+			"max-nodes",
+			parameters.ParameterTypeInteger,
+			parameters.WithHelp("Maximum number of nodes processed per document (0 for unlimited)"),
+			parameters.WithDefault(emrichen.DefaultLimits.MaxNodes),
+		),
+		parameters.NewParameterDefinition(
+			"max-loop-iterations",
+			parameters.ParameterTypeInteger,
+			parameters.WithHelp("Maximum number of !Loop iterations per document (0 for unlimited)"),
+			parameters.WithDefault(emrichen.DefaultLimits.MaxLoopIterations),
+		),
+		parameters.NewParameterDefinition(
+			"max-include-depth",
+			parameters.ParameterTypeInteger,
+			parameters.WithHelp("Maximum nesting depth of included files (0 for unlimited)"),
+			parameters.WithDefault(emrichen.DefaultLimits.MaxIncludeDepth),
+		),
+		parameters.NewParameterDefinition(
+			"max-include-bytes",
+			parameters.ParameterTypeInteger,
+			parameters.WithHelp("Maximum number of bytes read from included files per document (0 for unlimited)"),
+			parameters.WithDefault(int(emrichen.DefaultLimits.MaxIncludeBytes)),
+		),
+	}
+}
+
 func (c *ProcessCommand) RunIntoWriter(
 	ctx context.Context,
 	ps *layers.ParsedLayers,
@@ -93,47 +223,174 @@ func (c *ProcessCommand) RunIntoWriter(
 		return err
 	}
 
-	env := map[string]interface{}{}
+	if s.HelpTemplate != "" {
+		return printTemplateHelp(w, s.HelpTemplate)
+	}
+	if len(s.InputFiles) == 0 {
+		return errors.New("no input files")
+	}
 
-	for _, file := range s.VarFile {
-		// if the content is a list of objects, we want to merge them into the environment
-		if objs, ok := cast.CastList2[map[string]interface{}, interface{}](file.ParsedContent); ok {
-			for _, obj := range objs {
-				for k, v := range obj {
-					env[k] = v
-				}
-			}
-			continue
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(s.Timeout)*time.Second)
+		defer cancel()
+	}
+
+	ei, err := newInterpreter(ctx, s, nil)
+	if err != nil {
+		return err
+	}
+
+	var outputFile *atomicFile
+	if s.Output != "" && s.Output != "-" {
+		outputFile, err = createAtomicFile(s.Output)
+		if err != nil {
+			return err
 		}
+		w = outputFile
+	}
 
-		obj, ok := file.ParsedContent.(map[string]interface{})
-		if ok {
-			for k, v := range obj {
-				env[k] = v
-			}
-			continue
+	err = processFiles(ctx, ei, s, w)
+	if outputFile != nil {
+		if err != nil {
+			outputFile.Abort()
+			return err
 		}
+		return outputFile.Commit()
+	}
+
+	return err
+}
 
-		return errors.Errorf("could not cast %s to map[string]interface{}", file.Path)
+// newInterpreter creates the interpreter the input files are processed with,
+// holding the variables loaded from all sources and the defaults set by the
+// preludes. If origins is not nil, the origin of the loaded variables is
+// recorded in it.
+func newInterpreter(ctx context.Context, s *ProcessSettings, origins *origins) (*emrichen.Interpreter, error) {
+	options := []emrichen.InterpreterOption{}
+	if s.Sandbox {
+		if s.IncludeEnv {
+			return nil, errors.New("--include-env can't be used with --sandbox")
+		}
+		sandbox := emrichen.DefaultSandbox(s.IncludeRoot)
+		sandbox.AllowedTags = s.AllowTags
+		sandbox.DeniedTags = s.DenyTags
+		options = append(options, emrichen.WithSandbox(sandbox))
 	}
+	if s.IncludeEnv {
+		options = append(options, emrichen.WithEnviron(s.EnvNamespace, os.Environ()))
+	}
+	options = append(options,
+		emrichen.WithFuncMap(sprig.TxtFuncMap()),
+		emrichen.WithIncludePaths(s.IncludePath...),
+		emrichen.WithLimits(emrichen.Limits{
+			MaxDepth:          s.MaxDepth,
+			MaxNodes:          s.MaxNodes,
+			MaxLoopIterations: s.MaxLoop,
+			MaxIncludeDepth:   s.MaxInclude,
+			MaxIncludeBytes:   int64(s.MaxBytes),
+		}))
 
-	ei, err := emrichen.NewInterpreter(emrichen.WithVars(env),
-		emrichen.WithFuncMap(sprig.TxtFuncMap()))
+	env, err := loadVars(ctx, s, options, origins)
+	if err != nil {
+		return nil, err
+	}
+	err = origins.recordPaths(env)
+	if err != nil {
+		return nil, err
+	}
+
+	ei, err := emrichen.NewInterpreter(append(options, emrichen.WithVars(env))...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, prelude := range s.Prelude {
+		err := processFile(ctx, ei, prelude, preludeWriter{path: prelude})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ei, nil
+}
+
+// loadVars loads the variables passed to templates: the var files are read
+// in order, then the var file templates are rendered, and finally the
+// defines are applied, each overriding the previous ones. Mappings are
+// merged recursively.
+func loadVars(
+	ctx context.Context,
+	s *ProcessSettings,
+	options []emrichen.InterpreterOption,
+	origins *origins,
+) (map[string]interface{}, error) {
+	env := map[string]interface{}{}
+
+	for _, file := range s.VarFile {
+		err := mergeVarFile(env, file, origins)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, path := range s.VarFileTemplate {
+		err := mergeVarFileTemplate(ctx, env, path, options, origins)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, arg := range s.Define {
+		d, err := parseDefine(arg)
+		if err != nil {
+			return nil, err
+		}
+		origins.record(d.value, fixedOrigin("-D "+arg))
+		err = applyDefine(env, d)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not apply define %s", arg)
+		}
+	}
+
+	return env, nil
+}
+
+func processFiles(ctx context.Context, ei *emrichen.Interpreter, s *ProcessSettings, w io.Writer) error {
+	dw, err := newDocumentWriter(s.OutputFormat, s.JSONArray, w)
 	if err != nil {
 		return err
 	}
 
+	// each input file gets its own scope on top of the prelude, unless
+	// defaults are shared
 	for _, file := range s.InputFiles {
-		err := processFile(ei, file.Path, w)
+		fileInterpreter := ei
+		if !s.SharedDefaults {
+			fileInterpreter = ei.Clone()
+		}
+		err := processFile(ctx, fileInterpreter, file.Path, dw)
 		if err != nil {
 			return err
 		}
 	}
 
+	return dw.Close()
+}
+
+// preludeWriter rejects the documents produced by a prelude file, which is
+// only meant to set defaults.
+type preludeWriter struct {
+	path string
+}
+
+func (w preludeWriter) WriteDocument(*yaml.Node) error {
+	return errors.Errorf("prelude %s produces output, it should only contain !Defaults documents", w.path)
+}
+
+func (w preludeWriter) Close() error {
 	return nil
 }
 
-func processFile(interpreter *emrichen.Interpreter, filePath string, w io.Writer) error {
+func processFile(ctx context.Context, interpreter *emrichen.Interpreter, filePath string, w documentWriter) error {
 	f, err := os.Open(filePath)
 	if err != nil {
 		return err
@@ -142,13 +399,15 @@ func processFile(interpreter *emrichen.Interpreter, filePath string, w io.Writer
 		_ = f.Close()
 	}(f)
 
+	// relative includes are resolved against the directory of the file
+	interpreter.SetSourceFile(filePath)
+	defer interpreter.SetSourceFile("")
+
 	decoder := yaml.NewDecoder(f)
 
-	docCount := 0
 	for {
-		var document interface{}
-
-		err = decoder.Decode(interpreter.CreateDecoder(&document))
+		var input yaml.Node
+		err = decoder.Decode(&input)
 		if err == io.EOF {
 			break
 		}
@@ -156,29 +415,23 @@ func processFile(interpreter *emrichen.Interpreter, filePath string, w io.Writer
 			return err
 		}
 
-		// skip a document that was probably used to set !Defaults
-		if document == nil {
-			continue
-		}
-
-		processedYAML, err := yaml.Marshal(&document)
+		// an input document can expand into zero (e.g. !Defaults) or more
+		// (!Loop with as_documents) output documents
+		nodes, err := interpreter.ProcessDocumentsContext(ctx, &input)
 		if err != nil {
+			var emrichenErr *emrichen.Error
+			if errors.As(err, &emrichenErr) && emrichenErr.File == "" {
+				emrichenErr.File = filePath
+			}
 			return err
 		}
 
-		if docCount > 0 {
-			_, err = w.Write([]byte("---\n"))
+		for _, node := range nodes {
+			err = w.WriteDocument(node)
 			if err != nil {
 				return err
 			}
 		}
-
-		_, err = w.Write(processedYAML)
-		if err != nil {
-			return err
-		}
-
-		docCount += 1
 	}
 
 	return nil
@@ -189,6 +442,59 @@ var rootCmd *cobra.Command = &cobra.Command{
 	Short: "Emrichen is a YAML preprocessor",
 }
 
+// buildCobraCommand is like cli.BuildCobraCommandFromWriterCommand, but prints
+// processing errors with printError and returns them to cobra, instead of
+// exiting from within the command, so that deferred cleanups run.
+func buildCobraCommand(c cmds.WriterCommand) (*cobra.Command, error) {
+	var runErr error
+	cmd, err := cli.BuildCobraCommandFromCommandAndFunc(c, func(
+		ctx context.Context,
+		parsedLayers *layers.ParsedLayers,
+	) error {
+		runErr = c.RunIntoWriter(ctx, parsedLayers, os.Stdout)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the run func of glazed exits on errors, keep the error and return it
+	// once it is done
+	run := cmd.Run
+	cmd.Run = nil
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		runErr = nil
+		run(cmd, args)
+		if runErr != nil {
+			if errors.Is(runErr, context.Canceled) {
+				_, _ = fmt.Fprintln(os.Stderr, "Error: interrupted")
+			} else {
+				printError(runErr)
+			}
+		}
+		return runErr
+	}
+	// errors are printed above
+	cmd.SilenceErrors = true
+	cmd.SilenceUsage = true
+	return cmd, nil
+}
+
+// printError prints processing errors compiler-style (file:line:column: message),
+// followed by the chain of enclosing tags if the error is nested.
+func printError(err error) {
+	var emrichenErr *emrichen.Error
+	if !errors.As(err, &emrichenErr) {
+		_, _ = fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return
+	}
+
+	_, _ = fmt.Fprintln(os.Stderr, err)
+	if len(emrichenErr.Tags) > 1 {
+		_, _ = fmt.Fprintf(os.Stderr, "  in %s\n", emrichenErr.Breadcrumb())
+	}
+}
+
 func main() {
 	helpSystem := help.NewHelpSystem()
 	err := doc.AddDocToHelpSystem(helpSystem)
@@ -197,11 +503,23 @@ func main() {
 
 	processCmd, err := NewProcessCommand()
 	cobra.CheckErr(err)
-	processCommand, err := cli.BuildCobraCommandFromWriterCommand(processCmd)
+	processCommand, err := buildCobraCommand(processCmd)
 	cobra.CheckErr(err)
+	cobra.CheckErr(keepDefineCommas(processCommand))
 
 	rootCmd.AddCommand(processCommand)
 
-	err = rootCmd.Execute()
+	varsCmd, err := NewVarsCommand()
+	cobra.CheckErr(err)
+	varsCommand, err := cli.BuildCobraCommandFromGlazeCommand(varsCmd)
 	cobra.CheckErr(err)
+	cobra.CheckErr(keepDefineCommas(varsCommand))
+
+	rootCmd.AddCommand(varsCommand)
+
+	err = rootCmd.Execute()
+	if err != nil {
+		// cobra has printed the error, unless the command did
+		os.Exit(1)
+	}
 }
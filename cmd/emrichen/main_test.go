@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-go-golems/glazed/pkg/cmds/parameters"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessFilesScopesDefaults(t *testing.T) {
+	files := map[string]string{
+		"prelude.yaml": "!Defaults\nenv: prod\n",
+		"a.yaml":       "!Defaults\nname: a\n---\n{file: a, name: !Var name, env: !Var env}\n",
+		"b.yaml":       "{file: b, env: !Var env, name: !If {test: !Exists name, then: !Var name, else: unset}}\n",
+		"output.yaml":  "!Defaults\nenv: prod\n---\nstray: document\n",
+	}
+
+	tests := []struct {
+		name               string
+		inputFiles         []string
+		prelude            []string
+		sharedDefaults     bool
+		expected           string
+		expectErrorMessage string
+	}{
+		{
+			name:       "Defaults Do Not Leak Between Files",
+			inputFiles: []string{"a.yaml", "b.yaml"},
+			prelude:    []string{"prelude.yaml"},
+			expected: "{file: a, name: a, env: prod}\n---\n" +
+				"{file: b, env: prod, name: unset}\n",
+		},
+		{
+			name:           "Shared Defaults",
+			inputFiles:     []string{"a.yaml", "b.yaml"},
+			prelude:        []string{"prelude.yaml"},
+			sharedDefaults: true,
+			expected: "{file: a, name: a, env: prod}\n---\n" +
+				"{file: b, env: prod, name: a}\n",
+		},
+		{
+			name:               "Prelude Producing Output",
+			inputFiles:         []string{"b.yaml"},
+			prelude:            []string{"output.yaml"},
+			expectErrorMessage: "output.yaml produces output, it should only contain !Defaults documents",
+		},
+	}
+
+	dir := t.TempDir()
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &ProcessSettings{SharedDefaults: tt.sharedDefaults}
+			for _, name := range tt.inputFiles {
+				s.InputFiles = append(s.InputFiles, &parameters.FileData{Path: filepath.Join(dir, name)})
+			}
+			for _, name := range tt.prelude {
+				s.Prelude = append(s.Prelude, filepath.Join(dir, name))
+			}
+
+			ctx := context.Background()
+			ei, err := newInterpreter(ctx, s, nil)
+			if tt.expectErrorMessage != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.expectErrorMessage)
+				return
+			}
+			require.NoError(t, err)
+
+			var buf bytes.Buffer
+			require.NoError(t, processFiles(ctx, ei, s, &buf))
+
+			var actual []string
+			for _, document := range parseDocuments(t, buf.String()) {
+				actual = append(actual, marshalFlow(t, document.Content[0]))
+			}
+			var expected []string
+			for _, document := range parseDocuments(t, tt.expected) {
+				expected = append(expected, marshalFlow(t, document.Content[0]))
+			}
+			assert.Equal(t, expected, actual)
+		})
+	}
+}
+
+func TestBuildCobraCommandReturnsErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "input.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("a: 1\n"), 0o644))
+
+	processCmd, err := NewProcessCommand()
+	require.NoError(t, err)
+	cmd, err := buildCobraCommand(processCmd)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	cmd.SetArgs([]string{path})
+	err = cmd.ExecuteContext(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	require.NoError(t, os.WriteFile(path, []byte("a: !Var missing\n"), 0o644))
+	err = cmd.ExecuteContext(context.Background())
+	assert.ErrorContains(t, err, "variable missing not found")
+}
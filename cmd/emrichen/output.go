@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/go-go-golems/go-emrichen/pkg/env"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// documentWriter serializes processed documents to an output stream.
+// Close must be called once all documents have been written, since some
+// formats (e.g. a JSON array) only become valid once they are terminated.
+type documentWriter interface {
+	WriteDocument(document *yaml.Node) error
+	Close() error
+}
+
+func newDocumentWriter(format string, jsonArray bool, w io.Writer) (documentWriter, error) {
+	switch format {
+	case "", "yaml":
+		return &yamlDocumentWriter{w: w}, nil
+	case "json":
+		if jsonArray {
+			return &jsonArrayDocumentWriter{w: w}, nil
+		}
+		return &jsonDocumentWriter{encoder: newJSONEncoder(w, "")}, nil
+	case "pprint":
+		if jsonArray {
+			return &jsonArrayDocumentWriter{w: w, indent: "  "}, nil
+		}
+		return &jsonDocumentWriter{encoder: newJSONEncoder(w, "  ")}, nil
+	default:
+		return nil, errors.Errorf("unknown output format %q", format)
+	}
+}
+
+func newJSONEncoder(w io.Writer, indent string) *json.Encoder {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if indent != "" {
+		encoder.SetIndent("", indent)
+	}
+	return encoder
+}
+
+// yamlDocumentWriter writes documents as a YAML stream separated by `---`.
+type yamlDocumentWriter struct {
+	w        io.Writer
+	docCount int
+}
+
+func (y *yamlDocumentWriter) WriteDocument(document *yaml.Node) error {
+	processedYAML, err := yaml.Marshal(cleanNode(document))
+	if err != nil {
+		return err
+	}
+
+	if y.docCount > 0 {
+		_, err = y.w.Write([]byte("---\n"))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = y.w.Write(processedYAML)
+	if err != nil {
+		return err
+	}
+
+	y.docCount++
+	return nil
+}
+
+func (y *yamlDocumentWriter) Close() error {
+	return nil
+}
+
+// jsonDocumentWriter writes each document as a separate JSON value. Without
+// indentation, this results in one document per line.
+type jsonDocumentWriter struct {
+	encoder *json.Encoder
+}
+
+func (j *jsonDocumentWriter) WriteDocument(document *yaml.Node) error {
+	value, err := toJSONValue(document)
+	if err != nil {
+		return err
+	}
+	return j.encoder.Encode(value)
+}
+
+func (j *jsonDocumentWriter) Close() error {
+	return nil
+}
+
+// jsonArrayDocumentWriter collects all documents into a single JSON array.
+type jsonArrayDocumentWriter struct {
+	w         io.Writer
+	indent    string
+	documents []interface{}
+}
+
+func (j *jsonArrayDocumentWriter) WriteDocument(document *yaml.Node) error {
+	value, err := toJSONValue(document)
+	if err != nil {
+		return err
+	}
+	j.documents = append(j.documents, value)
+	return nil
+}
+
+func (j *jsonArrayDocumentWriter) Close() error {
+	documents := j.documents
+	if documents == nil {
+		documents = []interface{}{}
+	}
+	return newJSONEncoder(j.w, j.indent).Encode(documents)
+}
+
+// toJSONValue converts a processed document into a value that can be
+// marshalled by encoding/json. Mappings are converted to ordered maps to keep
+// their key order. YAML allows non-string mapping keys, which are converted
+// to their string representation.
+func toJSONValue(node *yaml.Node) (interface{}, error) {
+	//exhaustive:ignore
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil, nil
+		}
+		return toJSONValue(node.Content[0])
+	case yaml.AliasNode:
+		return toJSONValue(node.Alias)
+	case yaml.MappingNode:
+		ret := env.NewOrderedMap()
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			value, err := toJSONValue(node.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			ret.Set(node.Content[i].Value, value)
+		}
+		return ret, nil
+	case yaml.SequenceNode:
+		ret := make([]interface{}, 0, len(node.Content))
+		for _, item := range node.Content {
+			value, err := toJSONValue(item)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, value)
+		}
+		return ret, nil
+	default:
+		var ret interface{}
+		err := node.Decode(&ret)
+		return ret, err
+	}
+}
+
+// cleanNode returns a copy of a processed document without the comments,
+// anchors and formatting of the template, so that the output is formatted
+// consistently.
+func cleanNode(node *yaml.Node) *yaml.Node {
+	if node.Kind == yaml.AliasNode {
+		return cleanNode(node.Alias)
+	}
+
+	ret := *node
+	ret.HeadComment, ret.LineComment, ret.FootComment = "", "", ""
+	ret.Anchor = ""
+	ret.Style = 0
+	if ret.Kind == yaml.ScalarNode && ret.Tag == "!!str" {
+		// let the encoder quote strings like it does for Go strings, e.g.
+		// "yes" which YAML 1.1 parsers read as a boolean
+		var encoded yaml.Node
+		if err := encoded.Encode(ret.Value); err == nil {
+			ret.Style = encoded.Style
+		}
+	}
+	if len(node.Content) > 0 {
+		ret.Content = make([]*yaml.Node, len(node.Content))
+		for i, child := range node.Content {
+			ret.Content[i] = cleanNode(child)
+		}
+	}
+	return &ret
+}
+
+// atomicFile writes to a temporary file next to its destination and only
+// replaces the destination once Commit is called, so that readers never
+// observe a partially written output file.
+type atomicFile struct {
+	*os.File
+	path string
+}
+
+func createAtomicFile(path string) (*atomicFile, error) {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := os.CreateTemp(dir, "."+base+".tmp-*")
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not create output file for %s", path)
+	}
+	return &atomicFile{File: f, path: path}, nil
+}
+
+// Commit flushes the temporary file and renames it to its destination,
+// keeping the permissions of an existing destination file.
+func (f *atomicFile) Commit() error {
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(f.path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Abort()
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Abort()
+		return err
+	}
+	if err := f.File.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), f.path); err != nil {
+		_ = os.Remove(f.Name())
+		return errors.Wrapf(err, "could not write output file %s", f.path)
+	}
+	return nil
+}
+
+// Abort discards the temporary file, leaving the destination untouched.
+func (f *atomicFile) Abort() {
+	_ = f.File.Close()
+	_ = os.Remove(f.Name())
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestDocumentWriters(t *testing.T) {
+	documents := "# comment\nb: &x 1\na: [*x, yes, \"no\"]\n---\n\"hello\"\n"
+	tests := []struct {
+		name      string
+		format    string
+		jsonArray bool
+		input     string
+		expected  string
+	}{
+		{
+			name:     "YAML",
+			format:   "yaml",
+			input:    documents,
+			expected: "b: 1\na:\n    - 1\n    - \"yes\"\n    - \"no\"\n---\nhello\n",
+		},
+		{
+			name:     "JSON Lines",
+			format:   "json",
+			input:    documents,
+			expected: "{\"b\":1,\"a\":[1,\"yes\",\"no\"]}\n\"hello\"\n",
+		},
+		{
+			name:      "JSON Array",
+			format:    "json",
+			jsonArray: true,
+			input:     documents,
+			expected:  "[{\"b\":1,\"a\":[1,\"yes\",\"no\"]},\"hello\"]\n",
+		},
+		{
+			name:     "Pretty JSON",
+			format:   "pprint",
+			input:    "a: [1]\n",
+			expected: "{\n  \"a\": [\n    1\n  ]\n}\n",
+		},
+		{
+			name:      "Pretty JSON Array",
+			format:    "pprint",
+			jsonArray: true,
+			input:     "a: 1\n",
+			expected:  "[\n  {\n    \"a\": 1\n  }\n]\n",
+		},
+		{
+			name:     "Empty YAML",
+			format:   "yaml",
+			expected: "",
+		},
+		{
+			name:      "Empty JSON Array",
+			format:    "json",
+			jsonArray: true,
+			expected:  "[]\n",
+		},
+		{
+			name:     "Non String Keys And HTML",
+			format:   "json",
+			input:    "1: <a>\ntrue: null\n",
+			expected: "{\"1\":\"<a>\",\"true\":null}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := newDocumentWriter(tt.format, tt.jsonArray, &buf)
+			require.NoError(t, err)
+
+			if tt.input != "" {
+				for _, document := range parseDocuments(t, tt.input) {
+					require.NoError(t, w.WriteDocument(document))
+				}
+			}
+			require.NoError(t, w.Close())
+			assert.Equal(t, tt.expected, buf.String())
+		})
+	}
+
+	_, err := newDocumentWriter("xml", false, &bytes.Buffer{})
+	assert.EqualError(t, err, `unknown output format "xml"`)
+}
+
+func TestCleanNodeQuoting(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{name: "Plain String", input: "'hello'", expected: "hello\n"},
+		{name: "YAML 1.1 Boolean", input: "'yes'", expected: "\"yes\"\n"},
+		{name: "Numeric String", input: "'123'", expected: "\"123\"\n"},
+		{name: "Null String", input: "'null'", expected: "\"null\"\n"},
+		{name: "Multiline String", input: "|\n  a\n  b\n", expected: "|\n    a\n    b\n"},
+		{name: "Flow Style Dropped", input: "{a: [1, 2]}", expected: "a:\n    - 1\n    - 2\n"},
+		{name: "Comments Dropped", input: "a: 1 # one\n# foot\n", expected: "a: 1\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := &yamlDocumentWriter{w: &buf}
+			documents := parseDocuments(t, tt.input)
+			require.Len(t, documents, 1)
+			require.NoError(t, w.WriteDocument(documents[0]))
+			assert.Equal(t, tt.expected, buf.String())
+		})
+	}
+}
+
+func TestAtomicFileCommit(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.yaml")
+
+	f, err := createAtomicFile(path)
+	require.NoError(t, err)
+	_, err = f.WriteString("a: 1\n")
+	require.NoError(t, err)
+
+	// nothing is visible before the commit
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	require.NoError(t, f.Commit())
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "a: 1\n", string(content))
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), fi.Mode().Perm())
+	assertOnlyFiles(t, dir, "out.yaml")
+}
+
+func TestAtomicFileKeepsPermissions(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("old\n"), 0600))
+	require.NoError(t, os.Chmod(path, 0600))
+
+	f, err := createAtomicFile(path)
+	require.NoError(t, err)
+	_, err = f.WriteString("new\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Commit())
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "new\n", string(content))
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+}
+
+func TestAtomicFileAbort(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("old\n"), 0644))
+
+	f, err := createAtomicFile(path)
+	require.NoError(t, err)
+	_, err = f.WriteString("partial")
+	require.NoError(t, err)
+	f.Abort()
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "old\n", string(content))
+	assertOnlyFiles(t, dir, "out.yaml")
+}
+
+func TestAtomicFileMissingDirectory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing", "out.yaml")
+	_, err := createAtomicFile(path)
+	assert.ErrorContains(t, err, "could not create output file for "+path)
+}
+
+func parseDocuments(t *testing.T, s string) []*yaml.Node {
+	var ret []*yaml.Node
+	decoder := yaml.NewDecoder(strings.NewReader(s))
+	for {
+		document := &yaml.Node{}
+		err := decoder.Decode(document)
+		if err == io.EOF {
+			return ret
+		}
+		require.NoError(t, err)
+		ret = append(ret, document)
+	}
+}
+
+func assertOnlyFiles(t *testing.T, dir string, names ...string) {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	var actual []string
+	for _, entry := range entries {
+		actual = append(actual, entry.Name())
+	}
+	assert.Equal(t, names, actual)
+}
@@ -0,0 +1,437 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/go-go-golems/glazed/pkg/cmds/parameters"
+	"github.com/go-go-golems/glazed/pkg/helpers/cast"
+	"github.com/go-go-golems/go-emrichen/pkg/emrichen"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// mergeVarFile adds the variables of a --var-file to vars, merging mappings
+// with the variables already defined (see mergeVar). YAML, JSON and TOML
+// files are read as nodes, so that mappings keep their key order, .env files
+// define string variables. The origin of the variables is recorded in origins.
+func mergeVarFile(vars map[string]interface{}, file *parameters.FileData, origins *origins) error {
+	var root *yaml.Node
+	var err error
+	origin := lineOrigin(file.Path)
+	switch {
+	case file.FileType == parameters.YAML || file.FileType == parameters.JSON:
+		root, err = parseYAMLVarFile(file.Content)
+	case file.Extension == ".toml":
+		// keys are not decoded with their position
+		root, err = parseTOMLVarFile(file.Content)
+		origin = fixedOrigin(file.Path)
+	case file.Extension == ".env" || strings.HasPrefix(file.BaseName, ".env"):
+		root, err = parseDotEnvVarFile(file.Content)
+	default:
+		return mergeParsedVarFile(vars, file, origins)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "could not parse %s", file.Path)
+	}
+	origins.record(root, origin)
+
+	return mergeVarDocument(vars, root, file.Path)
+}
+
+// mergeVarDocument merges the variables of a mapping, or of a list of
+// mappings, into vars.
+func mergeVarDocument(vars map[string]interface{}, root *yaml.Node, path string) error {
+	if root.Kind == yaml.DocumentNode && len(root.Content) == 1 {
+		root = root.Content[0]
+	}
+
+	// a list of objects is merged into the variables
+	objects := []*yaml.Node{root}
+	if root.Kind == yaml.SequenceNode {
+		objects = root.Content
+	}
+	for _, object := range objects {
+		if object.Kind == yaml.AliasNode {
+			object = object.Alias
+		}
+		if object.Kind != yaml.MappingNode {
+			return errors.Errorf("could not cast %s to map[string]interface{}", path)
+		}
+		for i := 0; i+1 < len(object.Content); i += 2 {
+			err := mergeVar(vars, object.Content[i].Value, object.Content[i+1])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mergeParsedVarFile merges the content of other files, as parsed by glazed.
+func mergeParsedVarFile(vars map[string]interface{}, file *parameters.FileData, origins *origins) error {
+	// if the content is a list of objects, we want to merge them into the environment
+	objs, ok := cast.CastList2[map[string]interface{}, interface{}](file.ParsedContent)
+	if !ok {
+		obj, ok := file.ParsedContent.(map[string]interface{})
+		if !ok {
+			return errors.Errorf("could not cast %s to map[string]interface{}", file.Path)
+		}
+		objs = []map[string]interface{}{obj}
+	}
+
+	for _, obj := range objs {
+		for k, v := range obj {
+			node, err := emrichen.ValueToNode(v)
+			if err != nil {
+				return err
+			}
+			origins.record(node, fixedOrigin(file.Path))
+			err = mergeVar(vars, k, node)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mergeVar sets the variable name to value. If both value and the current
+// value of the variable are mappings, they are merged recursively instead,
+// with the keys of value taking precedence. Other values, including lists,
+// are replaced.
+func mergeVar(vars map[string]interface{}, name string, value *yaml.Node) error {
+	existing, ok := vars[name]
+	if !ok {
+		vars[name] = value
+		return nil
+	}
+	existingNode, err := emrichen.ValueToNode(existing)
+	if err != nil {
+		return err
+	}
+	vars[name] = mergeNodes(existingNode, value)
+	return nil
+}
+
+func mergeNodes(dst *yaml.Node, src *yaml.Node) *yaml.Node {
+	if dst.Kind == yaml.AliasNode {
+		dst = dst.Alias
+	}
+	if src.Kind == yaml.AliasNode {
+		src = src.Alias
+	}
+	if dst.Kind != yaml.MappingNode || src.Kind != yaml.MappingNode {
+		return src
+	}
+
+	ret := copyNode(dst)
+	// the merged mapping is a new value, which aliases of dst don't refer to
+	ret.Anchor = ""
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+		merged := false
+		for j := 0; j+1 < len(ret.Content); j += 2 {
+			if ret.Content[j].Value == key.Value {
+				ret.Content[j+1] = mergeNodes(ret.Content[j+1], value)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			ret.Content = append(ret.Content, key, value)
+		}
+	}
+	return ret
+}
+
+// parseYAMLVarFile parses a YAML or JSON var file, with its aliases and merge
+// keys expanded, since variables are used without their document.
+func parseYAMLVarFile(content string) (*yaml.Node, error) {
+	var document yaml.Node
+	err := yaml.Unmarshal([]byte(content), &document)
+	if err != nil {
+		return nil, err
+	}
+	return expandVarNode(&document)
+}
+
+// expandVarNode returns a copy of node with aliases replaced by (copies of)
+// the nodes they refer to, and `<<` merge keys applied: keys of a mapping take
+// precedence over merged keys, earlier merged mappings over later ones, and
+// merged keys are inserted at the position of the merge key.
+func expandVarNode(node *yaml.Node) (*yaml.Node, error) {
+	for node.Kind == yaml.AliasNode {
+		if node.Alias == nil {
+			return nil, errors.Errorf("alias *%s does not refer to an anchor", node.Value)
+		}
+		node = node.Alias
+	}
+
+	ret := *node
+	ret.Anchor = ""
+	if len(node.Content) == 0 {
+		return &ret, nil
+	}
+	ret.Content = make([]*yaml.Node, 0, len(node.Content))
+
+	if node.Kind != yaml.MappingNode {
+		for _, child := range node.Content {
+			expanded, err := expandVarNode(child)
+			if err != nil {
+				return nil, err
+			}
+			ret.Content = append(ret.Content, expanded)
+		}
+		return &ret, nil
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if !isVarMergeKey(node.Content[i]) {
+			seen[node.Content[i].Value] = true
+		}
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if !isVarMergeKey(key) {
+			expanded, err := expandVarNode(value)
+			if err != nil {
+				return nil, err
+			}
+			ret.Content = append(ret.Content, key, expanded)
+			continue
+		}
+
+		source, err := expandVarNode(value)
+		if err != nil {
+			return nil, err
+		}
+		sources := []*yaml.Node{source}
+		if source.Kind == yaml.SequenceNode {
+			sources = source.Content
+		}
+		for _, source := range sources {
+			if source.Kind != yaml.MappingNode {
+				return nil, errors.Errorf("line %d: merge key value must be a mapping or a sequence of mappings", key.Line)
+			}
+			for j := 0; j+1 < len(source.Content); j += 2 {
+				if seen[source.Content[j].Value] {
+					continue
+				}
+				seen[source.Content[j].Value] = true
+				ret.Content = append(ret.Content, source.Content[j], source.Content[j+1])
+			}
+		}
+	}
+	return &ret, nil
+}
+
+func isVarMergeKey(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.Value == "<<" && node.ShortTag() == "!!merge"
+}
+
+// parseTOMLVarFile parses a TOML file into a mapping node, keeping the order
+// in which keys are defined.
+func parseTOMLVarFile(content string) (*yaml.Node, error) {
+	var values map[string]interface{}
+	md, err := toml.Decode(content, &values)
+	if err != nil {
+		return nil, err
+	}
+
+	order := map[string]int{}
+	for i, key := range md.Keys() {
+		k := strings.Join(key, "\x00")
+		if _, ok := order[k]; !ok {
+			order[k] = i
+		}
+	}
+	return tomlToNode(values, nil, order)
+}
+
+func tomlToNode(v interface{}, path []string, order map[string]int) (*yaml.Node, error) {
+	switch v_ := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v_))
+		for k := range v_ {
+			keys = append(keys, k)
+		}
+		position := func(k string) int {
+			if i, ok := order[strings.Join(append(path, k), "\x00")]; ok {
+				return i
+			}
+			return len(order)
+		}
+		sort.SliceStable(keys, func(i, j int) bool {
+			pi, pj := position(keys[i]), position(keys[j])
+			if pi != pj {
+				return pi < pj
+			}
+			return keys[i] < keys[j]
+		})
+
+		ret := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		for _, k := range keys {
+			value, err := tomlToNode(v_[k], append(path[:len(path):len(path)], k), order)
+			if err != nil {
+				return nil, err
+			}
+			key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k}
+			ret.Content = append(ret.Content, key, value)
+		}
+		return ret, nil
+
+	case []map[string]interface{}:
+		ret := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, item := range v_ {
+			node, err := tomlToNode(item, path, order)
+			if err != nil {
+				return nil, err
+			}
+			ret.Content = append(ret.Content, node)
+		}
+		return ret, nil
+
+	case []interface{}:
+		ret := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, item := range v_ {
+			node, err := tomlToNode(item, path, order)
+			if err != nil {
+				return nil, err
+			}
+			ret.Content = append(ret.Content, node)
+		}
+		return ret, nil
+
+	default:
+		ret := &yaml.Node{}
+		if err := ret.Encode(v); err != nil {
+			return nil, err
+		}
+		return ret, nil
+	}
+}
+
+// parseDotEnvVarFile parses a .env file into a mapping of strings. Lines have
+// the form `[export] KEY=value`, values can be single quoted (literal) or
+// double quoted (supporting escapes like \n), and # starts a comment outside
+// of quotes.
+func parseDotEnvVarFile(content string) (*yaml.Node, error) {
+	ret := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	index := map[string]int{}
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		text = strings.TrimPrefix(text, "export ")
+
+		key, value, ok := strings.Cut(text, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, errors.Errorf("line %d: expected KEY=value", line)
+		}
+		value, err := parseDotEnvValue(strings.TrimSpace(value))
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", line)
+		}
+
+		valueNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value, Line: line, Column: 1}
+		if i, ok := index[key]; ok {
+			ret.Content[i+1] = valueNode
+			continue
+		}
+		index[key] = len(ret.Content)
+		keyNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}
+		ret.Content = append(ret.Content, keyNode, valueNode)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+func parseDotEnvValue(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "'"):
+		end := strings.Index(value[1:], "'")
+		if end < 0 {
+			return "", errors.New("unterminated single quoted value")
+		}
+		return value[1 : end+1], nil
+
+	case strings.HasPrefix(value, `"`):
+		// find the closing quote, skipping escaped characters
+		end := -1
+		for i := 1; i < len(value); i++ {
+			if value[i] == '\\' {
+				i++
+				continue
+			}
+			if value[i] == '"' {
+				end = i
+				break
+			}
+		}
+		if end < 0 {
+			return "", errors.New("unterminated double quoted value")
+		}
+		unquoted, err := strconv.Unquote(value[:end+1])
+		if err != nil {
+			return "", errors.Wrap(err, "invalid double quoted value")
+		}
+		return unquoted, nil
+
+	default:
+		if i := strings.Index(value, " #"); i >= 0 {
+			value = value[:i]
+		}
+		return strings.TrimSpace(value), nil
+	}
+}
+
+// varFileTemplateWriter merges the documents rendered from a
+// --var-file-template into vars.
+type varFileTemplateWriter struct {
+	vars    map[string]interface{}
+	path    string
+	origins *origins
+}
+
+func (w *varFileTemplateWriter) WriteDocument(document *yaml.Node) error {
+	// values taken from other variables keep their origin
+	w.origins.record(document, lineOrigin(w.path))
+	return mergeVarDocument(w.vars, document, w.path)
+}
+
+func (w *varFileTemplateWriter) Close() error {
+	return nil
+}
+
+// mergeVarFileTemplate renders a var file containing emrichen tags with the
+// variables loaded so far, and merges the result into vars.
+func mergeVarFileTemplate(
+	ctx context.Context,
+	vars map[string]interface{},
+	path string,
+	options []emrichen.InterpreterOption,
+	origins *origins,
+) error {
+	// the interpreter copies vars when pushing them, so that the rendered
+	// variables can be merged into it
+	ei, err := emrichen.NewInterpreter(append(options, emrichen.WithVars(vars))...)
+	if err != nil {
+		return err
+	}
+	return processFile(ctx, ei, path, &varFileTemplateWriter{vars: vars, path: path, origins: origins})
+}
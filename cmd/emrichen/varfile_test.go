@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-go-golems/glazed/pkg/cmds/parameters"
+	"github.com/go-go-golems/go-emrichen/pkg/emrichen"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseDotEnvValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected string
+		err      string
+	}{
+		{name: "Plain", value: "hello", expected: "hello"},
+		{name: "Empty", value: "", expected: ""},
+		{name: "Inline Comment", value: "hello # a comment", expected: "hello"},
+		{name: "Hash Without Space", value: "color#1", expected: "color#1"},
+		{name: "Single Quoted", value: `'a # b \n'`, expected: `a # b \n`},
+		{name: "Single Quoted With Comment", value: `'a' # comment`, expected: "a"},
+		{name: "Double Quoted Escapes", value: `"line1\nline2\t\"x\""`, expected: "line1\nline2\t\"x\""},
+		{name: "Double Quoted Hash", value: `"a # b" # comment`, expected: "a # b"},
+		{name: "Unterminated Single Quote", value: `'abc`, err: "unterminated single quoted value"},
+		{name: "Unterminated Double Quote", value: `"abc\"`, err: "unterminated double quoted value"},
+		{name: "Invalid Escape", value: `"\q"`, err: "invalid double quoted value: invalid syntax"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := parseDotEnvValue(tt.value)
+			if tt.err != "" {
+				require.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, value)
+		})
+	}
+}
+
+func TestParseDotEnvVarFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+		err      string
+	}{
+		{
+			name:     "Simple",
+			content:  "A=1\nB=two\n",
+			expected: `{A: "1", B: two}`,
+		},
+		{
+			name:     "Comments And Blank Lines",
+			content:  "# header\n\nA=1 # one\n  # indented\nB=2\n",
+			expected: `{A: "1", B: "2"}`,
+		},
+		{
+			name:     "Export And Spaces",
+			content:  "export A = hello world\n",
+			expected: `{A: hello world}`,
+		},
+		{
+			name:     "Quoted",
+			content:  "A='x # y'\nB=\"a\\nb\"\n",
+			expected: "{A: 'x # y', B: \"a\\nb\"}",
+		},
+		{
+			name:     "Later Value Wins In Place",
+			content:  "A=1\nB=2\nA=3\n",
+			expected: `{A: "3", B: "2"}`,
+		},
+		{
+			name:    "Missing Equals",
+			content: "A=1\nB\n",
+			err:     "line 2: expected KEY=value",
+		},
+		{
+			name:    "Empty Key",
+			content: "=1\n",
+			err:     "line 1: expected KEY=value",
+		},
+		{
+			name:    "Unterminated Quote",
+			content: "A=1\nB='x\n",
+			err:     "line 2: unterminated single quoted value",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := parseDotEnvVarFile(tt.content)
+			if tt.err != "" {
+				require.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, marshalFlow(t, node))
+		})
+	}
+}
+
+func TestParseDotEnvVarFileLines(t *testing.T) {
+	node, err := parseDotEnvVarFile("# comment\nA=1\n\nB=2\n")
+	require.NoError(t, err)
+	require.Len(t, node.Content, 4)
+	assert.Equal(t, 2, node.Content[1].Line)
+	assert.Equal(t, 4, node.Content[3].Line)
+}
+
+func TestParseTOMLVarFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{
+			name:     "Key Order",
+			content:  "zeta = 1\nalpha = \"a\"\nmid = true\n",
+			expected: "{zeta: 1, alpha: a, mid: true}",
+		},
+		{
+			name:     "Tables Keep Order",
+			content:  "name = \"app\"\n[image]\ntag = \"v1\"\nrepo = \"nginx\"\n[db]\nport = 5432\n",
+			expected: "{name: app, image: {tag: v1, repo: nginx}, db: {port: 5432}}",
+		},
+		{
+			name:     "Inline Table And Arrays",
+			content:  "ports = [80, 443]\nlimits = {memory = \"1G\", cpu = 2}\n",
+			expected: "{ports: [80, 443], limits: {memory: 1G, cpu: 2}}",
+		},
+		{
+			name:     "Array Of Tables",
+			content:  "[[servers]]\nname = \"b\"\nip = \"1\"\n[[servers]]\nname = \"a\"\n",
+			expected: "{servers: [{name: b, ip: \"1\"}, {name: a}]}",
+		},
+		{
+			name:     "Float",
+			content:  "ratio = 0.5\n",
+			expected: "{ratio: 0.5}",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := parseTOMLVarFile(tt.content)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, marshalFlow(t, node))
+		})
+	}
+
+	_, err := parseTOMLVarFile("a = \n")
+	assert.Error(t, err)
+}
+
+func TestParseYAMLVarFileExpandsAliases(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+		err      string
+	}{
+		{
+			name:     "Alias",
+			content:  "base: &b [1, 2]\nlist: *b\n",
+			expected: "{base: [1, 2], list: [1, 2]}",
+		},
+		{
+			name:     "Nested Alias",
+			content:  "defaults: &d {image: {repo: nginx}}\napp: {settings: *d}\n",
+			expected: "{defaults: {image: {repo: nginx}}, app: {settings: {image: {repo: nginx}}}}",
+		},
+		{
+			name:     "Merge Key",
+			content:  "m: {<<: {k: 1}, j: 2}\n",
+			expected: "{m: {k: 1, j: 2}}",
+		},
+		{
+			name:     "Merge Key With Alias And Override",
+			content:  "base: &b {a: 1, b: 2}\nm:\n  <<: *b\n  b: 3\n",
+			expected: "{base: {a: 1, b: 2}, m: {a: 1, b: 3}}",
+		},
+		{
+			name:     "Merge Sequence",
+			content:  "x: &x {a: 1}\ny: &y {a: 2, b: 2}\nm: {<<: [*x, *y]}\n",
+			expected: "{x: {a: 1}, y: {a: 2, b: 2}, m: {a: 1, b: 2}}",
+		},
+		{
+			name:     "Quoted Key Is Not A Merge Key",
+			content:  "m: {\"<<\": 1}\n",
+			expected: `{m: {"<<": 1}}`,
+		},
+		{
+			name:    "Invalid Merge",
+			content: "m:\n  <<: 1\n",
+			err:     "line 2: merge key value must be a mapping or a sequence of mappings",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := parseYAMLVarFile(tt.content)
+			if tt.err != "" {
+				require.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, marshalFlow(t, node.Content[0]))
+		})
+	}
+}
+
+func TestVarFileAliasesInTemplates(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "vars.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("base: &b [1, 2]\nlist: *b\nm: {<<: {k: 1}, j: 2}\n"), 0o644))
+	fd, err := parameters.GetFileData(path)
+	require.NoError(t, err)
+
+	vars := map[string]interface{}{}
+	require.NoError(t, mergeVarFile(vars, fd, nil))
+
+	ei, err := emrichen.NewInterpreter(emrichen.WithVars(vars))
+	require.NoError(t, err)
+	var document yaml.Node
+	require.NoError(t, yaml.Unmarshal([]byte(`items: !Loop {over: !Var list, template: !Var item}
+m: !Var m
+k: !Exists m.k
+`), &document))
+	result, err := ei.Process(document.Content[0])
+	require.NoError(t, err)
+	assert.Equal(t, "{items: [1, 2], m: {k: 1, j: 2}, k: true}", marshalFlow(t, result))
+}
+
+func TestMergeNodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		dst      string
+		src      string
+		expected string
+	}{
+		{name: "Scalar Replaced", dst: "1", src: "2", expected: "2"},
+		{name: "Mapping Replaces Scalar", dst: "1", src: "{a: 1}", expected: "{a: 1}"},
+		{name: "Scalar Replaces Mapping", dst: "{a: 1}", src: "x", expected: "x"},
+		{name: "Keys Added In Order", dst: "{b: 1, a: 2}", src: "{c: 3}", expected: "{b: 1, a: 2, c: 3}"},
+		{name: "Keys Overridden In Place", dst: "{b: 1, a: 2}", src: "{b: 3}", expected: "{b: 3, a: 2}"},
+		{name: "Deep Merge", dst: "{x: {a: 1, b: {c: 2}}}", src: "{x: {b: {d: 3}}}", expected: "{x: {a: 1, b: {c: 2, d: 3}}}"},
+		{name: "Lists Replaced", dst: "{l: [1, 2]}", src: "{l: [3]}", expected: "{l: [3]}"},
+		{name: "Aliases", dst: "{a: &x {b: 1}, c: *x}", src: "{c: {d: 2}}", expected: "{a: &x {b: 1}, c: {b: 1, d: 2}}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := parseYAML(t, tt.dst)
+			original := marshalFlow(t, dst)
+
+			merged := mergeNodes(dst, parseYAML(t, tt.src))
+			assert.Equal(t, tt.expected, marshalFlow(t, merged))
+			// the destination is not modified
+			assert.Equal(t, original, marshalFlow(t, dst))
+		})
+	}
+}
+
+func TestMergeVarFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"base.yaml":  "image:\n  repo: nginx\n  tag: v1\nports: [80]\n",
+		"over.json":  `{"image": {"tag": "v2"}, "ports": [443]}`,
+		"extra.toml": "replicas = 3\n[image]\npull = \"always\"\n",
+		".env.local": "TOKEN=secret\n",
+		"list.yaml":  "- a: 1\n- b: 2\n",
+		"bad.yaml":   "- 1\n",
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+	fileData := func(name string) *parameters.FileData {
+		fd, err := parameters.GetFileData(filepath.Join(dir, name))
+		require.NoError(t, err)
+		return fd
+	}
+
+	vars := map[string]interface{}{}
+	origins := newOrigins()
+	for _, name := range []string{"base.yaml", "over.json", "extra.toml", ".env.local", "list.yaml"} {
+		require.NoError(t, mergeVarFile(vars, fileData(name), origins), name)
+	}
+
+	image := vars["image"].(*yaml.Node)
+	// JSON strings keep their quotes
+	assert.Equal(t, `{repo: nginx, tag: "v2", pull: always}`, marshalFlow(t, image))
+	assert.Equal(t, "[443]", marshalFlow(t, vars["ports"].(*yaml.Node)))
+	assert.Equal(t, "3", marshalFlow(t, vars["replicas"].(*yaml.Node)))
+	assert.Equal(t, "secret", marshalFlow(t, vars["TOKEN"].(*yaml.Node)))
+	assert.Equal(t, "1", marshalFlow(t, vars["a"].(*yaml.Node)))
+	assert.Equal(t, "2", marshalFlow(t, vars["b"].(*yaml.Node)))
+
+	// values keep the position of the file they were loaded from
+	assert.Equal(t, filepath.Join(dir, "base.yaml")+":2", origins.nodes[image.Content[1]])
+	assert.Equal(t, filepath.Join(dir, "over.json")+":1", origins.nodes[image.Content[3]])
+	assert.Equal(t, filepath.Join(dir, "extra.toml"), origins.nodes[image.Content[5]])
+	assert.Equal(t, filepath.Join(dir, ".env.local")+":1", origins.nodes[vars["TOKEN"].(*yaml.Node)])
+
+	err := mergeVarFile(vars, fileData("bad.yaml"), nil)
+	assert.EqualError(t, err, "could not cast "+filepath.Join(dir, "bad.yaml")+" to map[string]interface{}")
+}
+
+func TestMergeVarFileTemplate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "template.yaml")
+	content := `!Defaults
+suffix: -prod
+---
+image:
+  tag: !Format "{image.tag}{suffix}"
+url: !Format "https://{host}"
+---
+host: ignored.example.com
+`
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+
+	vars := map[string]interface{}{
+		"host":  "example.com",
+		"image": map[string]interface{}{"repo": "nginx", "tag": "v1"},
+	}
+	origins := newOrigins()
+	err := mergeVarFileTemplate(context.Background(), vars, path, nil, origins)
+	require.NoError(t, err)
+
+	image, err := emrichen.ValueToNode(vars["image"])
+	require.NoError(t, err)
+	assert.Equal(t, "{repo: nginx, tag: v1-prod}", marshalFlow(t, image))
+	assert.Equal(t, "https://example.com", marshalFlow(t, vars["url"].(*yaml.Node)))
+	// documents are merged in order, later ones overriding earlier ones
+	assert.Equal(t, "ignored.example.com", marshalFlow(t, vars["host"].(*yaml.Node)))
+	// generated values have no position
+	assert.Equal(t, path, origins.nodes[vars["url"].(*yaml.Node)])
+	assert.Equal(t, path+":8", origins.nodes[vars["host"].(*yaml.Node)])
+
+	require.NoError(t, os.WriteFile(path, []byte("a: !Var missing\n"), 0o644))
+	err = mergeVarFileTemplate(context.Background(), vars, path, nil, nil)
+	assert.Error(t, err)
+}
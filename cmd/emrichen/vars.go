@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-go-golems/glazed/pkg/cmds"
+	"github.com/go-go-golems/glazed/pkg/cmds/layers"
+	"github.com/go-go-golems/glazed/pkg/cmds/parameters"
+	"github.com/go-go-golems/glazed/pkg/middlewares"
+	"github.com/go-go-golems/glazed/pkg/settings"
+	"github.com/go-go-golems/glazed/pkg/types"
+	"github.com/go-go-golems/go-emrichen/pkg/emrichen"
+	"github.com/go-go-golems/go-emrichen/pkg/env"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// VarsCommand prints the variables templates are processed with, loaded like
+// ProcessCommand loads them, with the origin of each value.
+type VarsCommand struct {
+	*cmds.CommandDescription
+}
+
+var _ cmds.GlazeCommand = (*VarsCommand)(nil)
+
+func NewVarsCommand() (*VarsCommand, error) {
+	glazedParameterLayer, err := settings.NewGlazedParameterLayers()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create Glazed parameter layer")
+	}
+
+	return &VarsCommand{
+		CommandDescription: cmds.NewCommandDescription(
+			"vars",
+			cmds.WithShort("Show the variables templates are processed with, and where they come from"),
+			cmds.WithLong("Loads the variables like process does, and prints one row per value "+
+				"with its path and origin (file:line, -D flag, environment, !Defaults or !Params block). "+
+				"The !Defaults and !Params documents of the input files are evaluated, each input file "+
+				"getting its own rows unless --shared-defaults is set."),
+			cmds.WithArguments(
+				parameters.NewParameterDefinition(
+					"input-files",
+					parameters.ParameterTypeFileList,
+					parameters.WithHelp("Input files whose !Defaults and !Params to include"),
+				),
+			),
+			cmds.WithFlags(variableFlags()...),
+			cmds.WithLayersList(glazedParameterLayer),
+		),
+	}, nil
+}
+
+func (c *VarsCommand) RunIntoGlazeProcessor(
+	ctx context.Context,
+	ps *layers.ParsedLayers,
+	gp middlewares.Processor,
+) error {
+	s := &ProcessSettings{}
+	if err := ps.InitializeStruct(layers.DefaultSlug, s); err != nil {
+		return err
+	}
+
+	return listVariables(ctx, s, gp)
+}
+
+// listVariables emits the variables the input files of s are processed with.
+func listVariables(ctx context.Context, s *ProcessSettings, gp middlewares.Processor) error {
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(s.Timeout)*time.Second)
+		defer cancel()
+	}
+
+	origins := newOrigins()
+	ei, err := newInterpreter(ctx, s, origins)
+	if err != nil {
+		return err
+	}
+	rows := &variableRows{gp: gp, origins: origins}
+	if s.IncludeEnv {
+		rows.environ = emrichen.EnvironToMap(os.Environ())
+		rows.envNamespace = s.EnvNamespace
+	}
+
+	if len(s.InputFiles) == 0 {
+		return rows.add(ctx, "", ei)
+	}
+
+	// mirror the scoping of process: each input file is evaluated on top of
+	// the preludes, unless defaults are shared
+	for _, file := range s.InputFiles {
+		fileInterpreter := ei
+		if !s.SharedDefaults {
+			fileInterpreter = ei.Clone()
+		}
+		err := processDeclarations(ctx, fileInterpreter, file.Path)
+		if err != nil {
+			return err
+		}
+		if !s.SharedDefaults {
+			err = rows.add(ctx, file.Path, fileInterpreter)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if s.SharedDefaults {
+		return rows.add(ctx, "", ei)
+	}
+
+	return nil
+}
+
+// declarationTags are the tags of the documents declaring variables.
+var declarationTags = map[string]bool{
+	"!Defaults": true,
+	"!Params":   true,
+}
+
+// processDeclarations processes the documents of a file declaring variables
+// (!Defaults and !Params) like process does, skipping the other documents.
+func processDeclarations(ctx context.Context, interpreter *emrichen.Interpreter, filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer func(f io.Closer) {
+		_ = f.Close()
+	}(f)
+
+	interpreter.SetSourceFile(filePath)
+	defer interpreter.SetSourceFile("")
+
+	decoder := yaml.NewDecoder(f)
+	for {
+		var input yaml.Node
+		err = decoder.Decode(&input)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		root := &input
+		if root.Kind == yaml.DocumentNode && len(root.Content) == 1 {
+			root = root.Content[0]
+		}
+		if !declarationTags[root.Tag] {
+			continue
+		}
+		_, err = interpreter.ProcessDocumentsContext(ctx, &input)
+		if err != nil {
+			var emrichenErr *emrichen.Error
+			if errors.As(err, &emrichenErr) && emrichenErr.File == "" {
+				emrichenErr.File = filePath
+			}
+			return err
+		}
+	}
+}
+
+// origins records where the values of variables were loaded from. A nil
+// *origins records nothing.
+type origins struct {
+	// nodes are the origins of the loaded values.
+	nodes map[*yaml.Node]string
+	// paths are the origins of the loaded values by path, for values that are
+	// replaced by a copy, such as values converted by !Params.
+	paths map[string]string
+}
+
+func newOrigins() *origins {
+	return &origins{
+		nodes: map[*yaml.Node]string{},
+		paths: map[string]string{},
+	}
+}
+
+// record sets the origin of the leaves of node, which are the scalars and
+// empty collections, unless they already have one.
+func (o *origins) record(node *yaml.Node, origin func(leaf *yaml.Node) string) {
+	if o == nil || node == nil {
+		return
+	}
+	if node.Kind == yaml.AliasNode {
+		o.record(node.Alias, origin)
+		return
+	}
+	if len(node.Content) == 0 || node.Kind == yaml.ScalarNode {
+		if _, ok := o.nodes[node]; !ok {
+			o.nodes[node] = origin(node)
+		}
+		return
+	}
+	for i, child := range node.Content {
+		// skip mapping keys
+		if node.Kind == yaml.MappingNode && i%2 == 0 {
+			continue
+		}
+		o.record(child, origin)
+	}
+}
+
+// recordPaths records the origins of the leaves of vars by path.
+func (o *origins) recordPaths(vars map[string]interface{}) error {
+	if o == nil {
+		return nil
+	}
+	for name, v := range vars {
+		node, err := emrichen.ValueToNode(v)
+		if err != nil {
+			return err
+		}
+		walkLeaves(name, node, func(path string, leaf *yaml.Node) error {
+			if origin, ok := o.nodes[leaf]; ok {
+				o.paths[path] = origin
+			}
+			return nil
+		})
+	}
+	return nil
+}
+
+// lookup returns the origin of a leaf. byPath also looks the origin up by
+// path, for values that may have been copied.
+func (o *origins) lookup(path string, leaf *yaml.Node, byPath bool) (string, bool) {
+	if o == nil {
+		return "", false
+	}
+	if origin, ok := o.nodes[leaf]; ok {
+		return origin, true
+	}
+	if byPath {
+		origin, ok := o.paths[path]
+		return origin, ok
+	}
+	return "", false
+}
+
+// walkLeaves calls f with the leaves of node, which are the scalars and empty
+// collections, and their path below path.
+func walkLeaves(path string, node *yaml.Node, f func(path string, leaf *yaml.Node) error) error {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind == yaml.ScalarNode || len(node.Content) == 0 {
+		return f(path, node)
+	}
+
+	for i := 0; i < len(node.Content); i++ {
+		childPath := path + "[" + strconv.Itoa(i) + "]"
+		child := node.Content[i]
+		if node.Kind == yaml.MappingNode {
+			if i+1 >= len(node.Content) {
+				break
+			}
+			childPath = path + "." + child.Value
+			i++
+			child = node.Content[i]
+		}
+		err := walkLeaves(childPath, child, f)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lineOrigin returns the position of a leaf in path, or path if the leaf
+// position is not known.
+func lineOrigin(path string) func(*yaml.Node) string {
+	return func(leaf *yaml.Node) string {
+		if leaf.Line == 0 {
+			return path
+		}
+		return path + ":" + strconv.Itoa(leaf.Line)
+	}
+}
+
+func fixedOrigin(origin string) func(*yaml.Node) string {
+	return func(*yaml.Node) string {
+		return origin
+	}
+}
+
+// variableRows emits a row for each leaf of the variables of an interpreter.
+type variableRows struct {
+	gp      middlewares.Processor
+	origins *origins
+	// environ are the environment variables, if included, exposed under
+	// envNamespace.
+	environ      map[string]interface{}
+	envNamespace string
+}
+
+func (r *variableRows) add(ctx context.Context, file string, ei *emrichen.Interpreter) error {
+	variables, err := ei.Variables()
+	if err != nil {
+		return err
+	}
+
+	for _, variable := range variables {
+		fallback := ""
+		switch {
+		case variable.Default:
+			fallback = variable.Tag + " " + variable.Source
+		case r.environ != nil && (variable.Name == r.envNamespace || r.envNamespace == ""):
+			if _, ok := r.environ[variable.Name]; ok || r.envNamespace != "" {
+				fallback = "environment"
+			}
+		}
+
+		err := walkLeaves(variable.Name, variable.Value, func(path string, leaf *yaml.Node) error {
+			// values supplied by the caller may have been converted by !Params
+			origin, ok := r.origins.lookup(path, leaf, !variable.Default)
+			if !ok {
+				origin = fallback
+			}
+
+			row := types.NewRow()
+			if file != "" {
+				row.Set("file", file)
+			}
+			row.Set("path", path)
+			row.Set("value", env.NodeToValue(leaf))
+			row.Set("origin", origin)
+			return r.gp.AddRow(ctx, row)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-go-golems/glazed/pkg/cmds/parameters"
+	"github.com/go-go-golems/glazed/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rowCollector is a processor keeping the rows it is given.
+type rowCollector struct {
+	rows []types.Row
+}
+
+func (c *rowCollector) AddRow(_ context.Context, row types.Row) error {
+	c.rows = append(c.rows, row)
+	return nil
+}
+
+func (c *rowCollector) Close(context.Context) error {
+	return nil
+}
+
+// lines formats the rows as "path=value origin", with paths in origins made
+// relative to dir.
+func (c *rowCollector) lines(dir string) []string {
+	ret := []string{}
+	for _, row := range c.rows {
+		path, _ := row.Get("path")
+		value, _ := row.Get("value")
+		origin, _ := row.Get("origin")
+		line := fmt.Sprintf("%s=%v %s", path, value, origin)
+		ret = append(ret, strings.ReplaceAll(line, dir+string(filepath.Separator), ""))
+	}
+	return ret
+}
+
+func TestListVariables(t *testing.T) {
+	tests := []struct {
+		name            string
+		files           map[string]string
+		varFiles        []string
+		varFileTemplate []string
+		define          []string
+		inputFiles      []string
+		expected        []string
+	}{
+		{
+			name:     "Var File",
+			files:    map[string]string{"vars.yaml": "a: 1\nlist: [x, y]\nm: {}\n"},
+			varFiles: []string{"vars.yaml"},
+			expected: []string{
+				"a=1 vars.yaml:1",
+				"list[0]=x vars.yaml:2",
+				"list[1]=y vars.yaml:2",
+				"m=map[] vars.yaml:3",
+			},
+		},
+		{
+			name: "Var File Template Overrides Var File",
+			files: map[string]string{
+				"vars.yaml": "a: file\nb: file\n",
+				"tmpl.yaml": "b: !Format \"{a}-template\"\n",
+			},
+			varFiles:        []string{"vars.yaml"},
+			varFileTemplate: []string{"tmpl.yaml"},
+			expected: []string{
+				"a=file vars.yaml:1",
+				"b=file-template tmpl.yaml",
+			},
+		},
+		{
+			name: "Define Overrides Var File Template",
+			files: map[string]string{
+				"vars.yaml": "a: file\nb: file\n",
+				"tmpl.yaml": "b: template\n",
+			},
+			varFiles:        []string{"vars.yaml"},
+			varFileTemplate: []string{"tmpl.yaml"},
+			define:          []string{"b=define", "c.d=1"},
+			expected: []string{
+				"a=file vars.yaml:1",
+				"b=define -D b=define",
+				"c.d=1 -D c.d=1",
+			},
+		},
+		{
+			name: "Defaults Only Set Missing Variables",
+			files: map[string]string{
+				"vars.yaml": "a: file\n",
+				"in.yaml":   "!Defaults\na: default\nb: default\n---\nx: !Var b\n",
+			},
+			varFiles:   []string{"vars.yaml"},
+			inputFiles: []string{"in.yaml"},
+			expected: []string{
+				"a=file vars.yaml:1",
+				"b=default !Defaults in.yaml:3:4",
+			},
+		},
+		{
+			name: "Params",
+			files: map[string]string{
+				"in.yaml": "!Params\nport: {type: int, default: 80}\nreplicas: {type: int, default: 1}\n" +
+					"---\n!Defaults\nname: app\n",
+			},
+			define:     []string{"replicas=3"},
+			inputFiles: []string{"in.yaml"},
+			expected: []string{
+				"name=app !Defaults in.yaml:6:7",
+				"port=80 !Params in.yaml:2:28",
+				"replicas=3 -D replicas=3",
+			},
+		},
+		{
+			name: "Params Convert Supplied Values",
+			files: map[string]string{
+				"vars.yaml": "replicas: \"3\"\n",
+				"in.yaml":   "!Params\nreplicas: {type: int}\n",
+			},
+			varFiles:   []string{"vars.yaml"},
+			inputFiles: []string{"in.yaml"},
+			expected: []string{
+				"replicas=3 vars.yaml:1",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+			}
+
+			s := &ProcessSettings{Define: tt.define}
+			for _, name := range tt.varFiles {
+				fd, err := parameters.GetFileData(filepath.Join(dir, name))
+				require.NoError(t, err)
+				s.VarFile = append(s.VarFile, fd)
+			}
+			for _, name := range tt.varFileTemplate {
+				s.VarFileTemplate = append(s.VarFileTemplate, filepath.Join(dir, name))
+			}
+			for _, name := range tt.inputFiles {
+				s.InputFiles = append(s.InputFiles, &parameters.FileData{Path: filepath.Join(dir, name)})
+			}
+
+			gp := &rowCollector{}
+			require.NoError(t, listVariables(context.Background(), s, gp))
+			assert.Equal(t, tt.expected, gp.lines(dir))
+		})
+	}
+}
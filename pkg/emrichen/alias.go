@@ -0,0 +1,152 @@
+package emrichen
+
+import (
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// processAlias resolves an alias node to the result of processing its anchor.
+// If the anchored node has already been processed, its result is reused, so
+// that tags under an anchor are only evaluated once.
+func (ei *Interpreter) processAlias(node *yaml.Node) (*yaml.Node, error) {
+	if node.Alias == nil {
+		return nil, tagArgumentErrorf("alias *%s does not refer to an anchor", node.Value)
+	}
+	if ret, ok := ei.anchors[node.Alias]; ok {
+		return ret, nil
+	}
+	return ei.Process(node.Alias)
+}
+
+// recordAnchor stores the processed result of an anchored node so that
+// aliases to it can reuse it. The anchor itself is stripped from the result,
+// since every alias is replaced by the result.
+func (ei *Interpreter) recordAnchor(node *yaml.Node, result *yaml.Node) *yaml.Node {
+	if result != nil && result.Anchor != "" {
+		result_ := *result
+		result_.Anchor = ""
+		result = &result_
+	}
+	if ei.anchors == nil {
+		ei.anchors = map[*yaml.Node]*yaml.Node{}
+	}
+	ei.anchors[node] = result
+	return result
+}
+
+// resolveAlias follows alias nodes until it reaches a non-alias node.
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node != nil && node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	return node
+}
+
+// isMergeKey returns true if the node is a `<<` merge key.
+func isMergeKey(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode &&
+		node.Value == "<<" &&
+		(node.Tag == "!!merge" || node.Tag == "")
+}
+
+// expandMergeKeys returns the content of a mapping node with its `<<` merge keys
+// applied, following the YAML merge key semantics: keys of the mapping itself
+// take precedence over merged keys, and when merging a sequence of mappings,
+// earlier mappings take precedence over later ones. Merged keys are inserted at
+// the position of the merge key.
+//
+// Merging happens on unprocessed nodes, so that the merged values are then
+// processed as if they had been written out in the mapping. A merge value that
+// carries a tag is processed first, and must result in a mapping.
+func (ei *Interpreter) expandMergeKeys(node *yaml.Node) ([]*yaml.Node, error) {
+	hasMergeKey := false
+	for i := 0; i < len(node.Content); i += 2 {
+		if isMergeKey(node.Content[i]) {
+			hasMergeKey = true
+			break
+		}
+	}
+	if !hasMergeKey {
+		return node.Content, nil
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i < len(node.Content); i += 2 {
+		if !isMergeKey(node.Content[i]) {
+			seen[mappingKey(node.Content[i])] = true
+		}
+	}
+
+	ret := make([]*yaml.Node, 0, len(node.Content))
+	for i := 0; i < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if !isMergeKey(key) {
+			ret = append(ret, key, value)
+			continue
+		}
+
+		sources, err := ei.mergeSources(value)
+		if err != nil {
+			return nil, err
+		}
+		for _, source := range sources {
+			content, err := ei.expandMergeKeys(source)
+			if err != nil {
+				return nil, err
+			}
+			for j := 0; j < len(content); j += 2 {
+				if seen[mappingKey(content[j])] {
+					continue
+				}
+				seen[mappingKey(content[j])] = true
+				ret = append(ret, content[j], content[j+1])
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+// mergeSources returns the mappings referenced by the value of a merge key,
+// in order of precedence.
+func (ei *Interpreter) mergeSources(value *yaml.Node) ([]*yaml.Node, error) {
+	value = resolveAlias(value)
+	if value == nil {
+		return nil, tagArgumentErrorf("merge key value must be a mapping or a sequence of mappings")
+	}
+
+	var candidates []*yaml.Node
+	if value.Kind == yaml.SequenceNode && !isCustomTag(value.Tag) {
+		candidates = value.Content
+	} else {
+		candidates = []*yaml.Node{value}
+	}
+
+	ret := make([]*yaml.Node, 0, len(candidates))
+	for _, candidate := range candidates {
+		candidate = resolveAlias(candidate)
+		if candidate != nil && isCustomTag(candidate.Tag) {
+			processed, err := ei.Process(candidate)
+			if err != nil {
+				return nil, err
+			}
+			if processed == nil {
+				continue
+			}
+			candidate = processed
+		}
+		if candidate == nil || candidate.Kind != yaml.MappingNode {
+			return nil, tagArgumentErrorf("merge key value must be a mapping or a sequence of mappings")
+		}
+		ret = append(ret, candidate)
+	}
+
+	return ret, nil
+}
+
+// isCustomTag returns true for tags that are not part of the YAML core schema,
+// such as emrichen tags.
+func isCustomTag(tag string) bool {
+	return strings.HasPrefix(tag, "!") && !strings.HasPrefix(tag, "!!") && tag != "!"
+}
@@ -0,0 +1,153 @@
+package emrichen
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestEmrichenAnchorsAndAliases(t *testing.T) {
+	tests := []testCase{
+		{
+			name: "Alias to scalar",
+			inputYAML: `
+a: &name foo
+b: *name`,
+			expected: `
+a: foo
+b: foo`,
+		},
+		{
+			name: "Alias to mapping",
+			inputYAML: `
+defaults: &defaults
+  replicas: 2
+  image: nginx
+service: *defaults`,
+			expected: `
+defaults: {replicas: 2, image: nginx}
+service: {replicas: 2, image: nginx}`,
+		},
+		{
+			name: "Alias to tagged node",
+			inputYAML: `
+a: &greeting !Format "hello {name}"
+b: *greeting`,
+			initVars: map[string]interface{}{"name": "world"},
+			expected: `
+a: hello world
+b: hello world`,
+		},
+		{
+			name: "Alias inside a tag",
+			inputYAML: `
+items: &items [1, 2, 3]
+doubled: !Loop
+  over: *items
+  template: !Op {a: !Var item, op: "*", b: 2}`,
+			expected: `
+items: [1, 2, 3]
+doubled: [2, 4, 6]`,
+		},
+		{
+			name: "Alias to void node",
+			inputYAML: `
+a: &nothing !Void
+b: *nothing
+c: 1`,
+			expected: `
+c: 1`,
+		},
+		{
+			name: "Merge key",
+			inputYAML: `
+base: &base
+  image: nginx
+  replicas: 1
+web:
+  <<: *base
+  replicas: 3`,
+			expected: `
+base: {image: nginx, replicas: 1}
+web: {image: nginx, replicas: 3}`,
+		},
+		{
+			name: "Merge key with sequence of mappings",
+			inputYAML: `
+a: &a {x: 1, y: 1}
+b: &b {y: 2, z: 2}
+c:
+  <<: [*a, *b]`,
+			expected: `
+a: {x: 1, y: 1}
+b: {y: 2, z: 2}
+c: {x: 1, y: 1, z: 2}`,
+		},
+		{
+			name: "Merged values are processed",
+			inputYAML: `
+base: &base
+  name: !Var name
+web:
+  <<: *base
+  port: 80`,
+			initVars: map[string]interface{}{"name": "web"},
+			expected: `
+base: {name: web}
+web: {name: web, port: 80}`,
+		},
+		{
+			name: "Merge key in tag arguments",
+			inputYAML: `
+loopArgs: &loopArgs
+  over: [1, 2]
+  as: i
+result: !Loop
+  <<: *loopArgs
+  template: !Var i`,
+			expected: `
+loopArgs: {over: [1, 2], as: i}
+result: [1, 2]`,
+		},
+		{
+			name: "Merge key with tagged value",
+			inputYAML: `
+web:
+  <<: !Var defaults
+  replicas: 3`,
+			initVars: map[string]interface{}{"defaults": map[string]interface{}{"replicas": 1}},
+			expected: `
+web: {replicas: 3}`,
+		},
+		{
+			name: "Merge key with invalid value",
+			inputYAML: `
+web:
+  <<: 3`,
+			expectError: true,
+		},
+	}
+
+	runTests(t, tests)
+}
+
+func TestAnchorIsProcessedOnce(t *testing.T) {
+	count := 0
+	ei, err := NewInterpreter(WithAdditionalTags(TagFuncMap{
+		"!Count": func(ei *Interpreter, node *yaml.Node) (*yaml.Node, error) {
+			count++
+			return makeInt(count), nil
+		},
+	}))
+	require.NoError(t, err)
+
+	var result map[string]int
+	err = yaml.Unmarshal([]byte(`
+a: &counter !Count
+b: *counter
+c: *counter`), ei.CreateDecoder(&result))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"a": 1, "b": 1, "c": 1}, result)
+}
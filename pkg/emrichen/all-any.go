@@ -1,13 +1,12 @@
 package emrichen
 
 import (
-	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
 )
 
 func (ei *Interpreter) handleAll(node *yaml.Node) (*yaml.Node, error) {
 	if node.Kind != yaml.SequenceNode {
-		return nil, errors.New("!All requires a sequence node")
+		return nil, tagArgumentErrorf("!All requires a sequence node")
 	}
 
 	for _, item := range node.Content {
@@ -24,7 +23,7 @@ func (ei *Interpreter) handleAll(node *yaml.Node) (*yaml.Node, error) {
 
 func (ei *Interpreter) handleAny(node *yaml.Node) (*yaml.Node, error) {
 	if node.Kind != yaml.SequenceNode {
-		return nil, errors.New("!Any requires a sequence node")
+		return nil, tagArgumentErrorf("!Any requires a sequence node")
 	}
 
 	for _, item := range node.Content {
@@ -0,0 +1,128 @@
+package emrichen
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestCloneIsolatesVariables(t *testing.T) {
+	ei, err := NewInterpreter(WithVars(map[string]interface{}{"a": "base"}))
+	require.NoError(t, err)
+	processToYAML(t, ei, "!Defaults\nb: original\n")
+
+	clone := ei.Clone()
+	assert.Equal(t, "a: base\nb: original\n", processToYAML(t, clone, "a: !Var a\nb: !Var b\n"))
+
+	// defaults set by the clone are not visible to the original ...
+	processToYAML(t, clone, "!Defaults\nb: clone\nc: clone\n")
+	assert.Equal(t, "b: clone\nc: clone\n", processToYAML(t, clone, "b: !Var b\nc: !Var c\n"))
+	assert.Equal(t, "b: original\nc: false\n", processToYAML(t, ei, "b: !Var b\nc: !Exists c\n"))
+
+	// ... and the other way around
+	processToYAML(t, ei, "!Defaults\na: changed\n")
+	assert.Equal(t, "a: base\n", processToYAML(t, clone, "a: !Var a\n"))
+}
+
+func TestCloneSharesConfiguration(t *testing.T) {
+	ei, err := NewInterpreter(
+		WithLimits(Limits{MaxNodes: 3}),
+		WithSandbox(Sandbox{DeniedTags: []string{"!Base64"}}),
+	)
+	require.NoError(t, err)
+	clone := ei.Clone()
+
+	_, err = clone.Process(parseNode(t, "a: !Base64 foo"))
+	assert.ErrorIs(t, err, ErrSandboxViolation)
+
+	_, err = clone.Process(parseNode(t, "[1, 2, 3, 4]"))
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+}
+
+func TestCloneIsolatesTags(t *testing.T) {
+	ei, err := NewInterpreter()
+	require.NoError(t, err)
+	require.NoError(t, ei.RegisterTag("!Original", func(node *yaml.Node) (*yaml.Node, error) {
+		return makeString("original"), nil
+	}))
+
+	clone := ei.Clone()
+	assert.Equal(t, "a: original\n", processToYAML(t, clone, "a: !Original\n"))
+
+	// tags registered on the clone are not visible to the original ...
+	require.NoError(t, clone.RegisterTag("!Clone", func(node *yaml.Node) (*yaml.Node, error) {
+		return makeString("clone"), nil
+	}))
+	assert.Equal(t, "a: clone\n", processToYAML(t, clone, "a: !Clone\n"))
+	assert.NotEqual(t, "a: clone\n", processToYAML(t, ei, "a: !Clone\n"))
+
+	// ... and the other way around
+	require.NoError(t, ei.RegisterTag("!Clone", func(node *yaml.Node) (*yaml.Node, error) {
+		return makeString("registered later"), nil
+	}))
+	assert.Equal(t, "a: clone\n", processToYAML(t, clone, "a: !Clone\n"))
+}
+
+func TestCloneRegisterTagConcurrently(t *testing.T) {
+	ei, err := NewInterpreter()
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			clone := ei.Clone()
+			value := fmt.Sprintf("value-%d", i)
+			assert.NoError(t, clone.RegisterTag("!Value", func(node *yaml.Node) (*yaml.Node, error) {
+				return makeString(value), nil
+			}))
+			result, err := clone.Process(parseNode(t, "!Value"))
+			if assert.NoError(t, err) {
+				assert.Equal(t, value, result.Value)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestCloneConcurrently(t *testing.T) {
+	ei, err := NewInterpreter(WithVars(map[string]interface{}{"prefix": "item"}))
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			clone := ei.Clone()
+			n := i%3 + 1
+			values := ""
+			expected := fmt.Sprintf("name: item-%d\nitems:\n", n)
+			for j := 0; j < n; j++ {
+				values += fmt.Sprintf("  - %d\n", j)
+				expected += fmt.Sprintf("    - %d\n", j*10)
+			}
+			output := processToYAML(t, clone, fmt.Sprintf(`
+!Defaults
+n: %d
+values:
+%s---
+name: !Format "{prefix}-{n}"
+items: !Loop
+  over: !Var values
+  template: !Op
+    a: !Var item
+    op: "*"
+    b: 10
+`, n, values))
+
+			assert.Equal(t, expected, output)
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,175 @@
+package emrichen
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// tagArgumentSpecs lists the arguments of the tags taking a mapping of named
+// arguments, used to validate templates in Compile.
+var tagArgumentSpecs = map[string][]ParsedVariable{
+	"!Filter":    filterArgs,
+	"!Group":     groupArgs,
+	"!If":        ifArgs,
+	"!Index":     indexArgs,
+	"!Join":      joinArgs,
+	"!Loop":      loopArgs,
+	"!Merge":     mergeArgs,
+	"!Op":        opArgs,
+	"!URLEncode": urlEncodeArgs,
+}
+
+// Template is a parsed template, which can be rendered many times with
+// different variables. It is safe for concurrent use.
+type Template struct {
+	documents []*yaml.Node
+	// params are the parameters declared with !Params.
+	params []Param
+	// prototype is the interpreter configured by the options passed to
+	// Compile. It is cloned for every render.
+	prototype *Interpreter
+}
+
+// Compile parses all the documents read from r, and validates the arguments
+// of the tags taking a mapping of named arguments (such as !Loop or !If), so
+// that errors are reported before rendering. The options configure the
+// interpreters used by Render, variables passed with WithVars are available
+// to every render.
+//
+// The Go templates of !Format nodes are parsed once and shared by all
+// renders.
+func Compile(r io.Reader, options ...InterpreterOption) (*Template, error) {
+	prototype, err := NewInterpreter(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	var documents []*yaml.Node
+	var params []Param
+	decoder := yaml.NewDecoder(r)
+	for {
+		document := &yaml.Node{}
+		err := decoder.Decode(document)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		err = prototype.validateNode(document)
+		if err != nil {
+			return nil, err
+		}
+		documentParams, err := prototype.documentParams(document)
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, documentParams...)
+		documents = append(documents, document)
+	}
+
+	return &Template{
+		documents: documents,
+		params:    params,
+		prototype: prototype,
+	}, nil
+}
+
+// Params returns the parameters declared by the !Params documents of the
+// template, in order.
+func (t *Template) Params() []Param {
+	return t.params
+}
+
+// Render renders the template with vars, returning the output documents.
+// Variables set with !Defaults only affect the current render.
+func (t *Template) Render(vars map[string]interface{}) ([]*yaml.Node, error) {
+	return t.RenderContext(context.Background(), vars)
+}
+
+// RenderContext is like Render, but respects ctx and the configured limits
+// like ProcessContext.
+func (t *Template) RenderContext(ctx context.Context, vars map[string]interface{}) ([]*yaml.Node, error) {
+	ei := t.prototype.Clone()
+	ei.pushCallerVars(vars)
+
+	var ret []*yaml.Node
+	for _, document := range t.documents {
+		documents, err := ei.ProcessDocumentsContext(ctx, document)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, documents...)
+	}
+	return ret, nil
+}
+
+// validateNode checks the arguments of the tags of node and its children
+// against tagArgumentSpecs, and parses the format strings of !Format nodes.
+func (ei *Interpreter) validateNode(node *yaml.Node) error {
+	if node.Kind == yaml.AliasNode {
+		// the anchored node is validated where it is defined
+		return nil
+	}
+
+	// the last tag of a chain is applied to the node itself
+	tags := strings.Split(node.Tag, ",")
+	tag := tags[len(tags)-1]
+	if len(tags) > 1 && !strings.HasPrefix(tag, "!") {
+		tag = "!" + tag
+	}
+	if spec, ok := tagArgumentSpecs[tag]; ok && node.Kind == yaml.MappingNode {
+		err := validateArgs(node, spec)
+		if err != nil {
+			return ei.wrapError(err, tag, node)
+		}
+	}
+	if tag == "!Format" && node.Kind == yaml.ScalarNode {
+		// fills the format cache shared by the renders
+		_, err := ei.parseFormatString(node.Value)
+		if err != nil {
+			return ei.wrapError(err, tag, node)
+		}
+	}
+
+	for _, child := range node.Content {
+		err := ei.validateNode(child)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateArgs checks the keys of a mapping of tag arguments like ParseArgs,
+// without processing it.
+func validateArgs(node *yaml.Node, spec []ParsedVariable) error {
+	known := map[string]bool{}
+	for _, v := range spec {
+		known[v.Name] = true
+	}
+
+	present := map[string]bool{}
+	for i := 0; i < len(node.Content); i += 2 {
+		keyNode := node.Content[i]
+		if isMergeKey(keyNode) {
+			// merged arguments are only known after processing
+			return nil
+		}
+		if !known[keyNode.Value] {
+			return tagArgumentErrorf("unknown key '%s'", keyNode.Value)
+		}
+		present[keyNode.Value] = true
+	}
+
+	for _, v := range spec {
+		if v.Required && !present[v.Name] {
+			return tagArgumentErrorf("required key '%s' not found", v.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package emrichen
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func renderString(t *testing.T, tmpl *Template, vars map[string]interface{}) string {
+	documents, err := tmpl.Render(vars)
+	require.NoError(t, err)
+	var parts []string
+	for _, document := range documents {
+		parts = append(parts, marshalNode(t, document))
+	}
+	return strings.Join(parts, "---\n")
+}
+
+func TestCompileRender(t *testing.T) {
+	tmpl, err := Compile(strings.NewReader(`
+!Defaults
+greeting: hello
+---
+message: !Format "{greeting} {name}"
+items: !Loop
+  over: !Var items
+  template: !Format "{item}-{name}"
+`), WithVars(map[string]interface{}{"items": []interface{}{"a", "b"}}))
+	require.NoError(t, err)
+
+	assert.Equal(t, "message: hello world\nitems:\n    - a-world\n    - b-world\n",
+		renderString(t, tmpl, map[string]interface{}{"name": "world"}))
+	assert.Equal(t, "message: hello you\nitems:\n    - a-you\n    - b-you\n",
+		renderString(t, tmpl, map[string]interface{}{"name": "you"}))
+}
+
+func TestCompileRenderDoesNotLeakDefaults(t *testing.T) {
+	tmpl, err := Compile(strings.NewReader(`
+!Defaults
+a: !Var b
+---
+a: !Var a
+`))
+	require.NoError(t, err)
+
+	assert.Equal(t, "a: 1\n", renderString(t, tmpl, map[string]interface{}{"b": 1}))
+	assert.Equal(t, "a: 2\n", renderString(t, tmpl, map[string]interface{}{"b": 2}))
+}
+
+func TestCompileValidatesTagArguments(t *testing.T) {
+	tests := []struct {
+		name          string
+		inputYAML     string
+		expectedError string
+	}{
+		{
+			name:          "UnknownKey",
+			inputYAML:     "a: !Loop\n  over: [1]\n  tempate: !Var item\n",
+			expectedError: "unknown key 'tempate'",
+		},
+		{
+			name:          "MissingRequiredKey",
+			inputYAML:     "a: !If\n  then: 1\n",
+			expectedError: "required key 'test' not found",
+		},
+		{
+			name:          "NestedInChain",
+			inputYAML:     "a:\n  - !Base64,Join\n    items: [a]\n    sep: ','\n",
+			expectedError: "unknown key 'sep'",
+		},
+		{
+			name:          "InvalidFormatString",
+			inputYAML:     "a: !Format \"{{ .a \"\n",
+			expectedError: "error parsing format string",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile(strings.NewReader(tt.inputYAML))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.expectedError)
+
+			var emrichenErr *Error
+			require.True(t, errors.As(err, &emrichenErr))
+			assert.Greater(t, emrichenErr.Line, 0)
+		})
+	}
+}
+
+func TestCompileRenderConcurrently(t *testing.T) {
+	tmpl, err := Compile(strings.NewReader(`
+id: !Format "id-{n}"
+squares: !Loop
+  over: !Var values
+  template: !Op
+    a: !Var item
+    op: "*"
+    b: !Var item
+`))
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			documents, err := tmpl.Render(map[string]interface{}{
+				"n":      i,
+				"values": []interface{}{i, i + 1},
+			})
+			if !assert.NoError(t, err) {
+				return
+			}
+			expected := fmt.Sprintf("id: id-%d\nsquares:\n    - %d\n    - %d\n", i, i*i, (i+1)*(i+1))
+			assert.Equal(t, expected, marshalNode(t, documents[0]))
+		}(i)
+	}
+	wg.Wait()
+}
+
+func BenchmarkTemplateRender(b *testing.B) {
+	tmpl, err := Compile(strings.NewReader(`
+name: !Format "{first} {last}"
+items: !Loop
+  over: !Var items
+  template: !Format "{item}: {first}"
+`))
+	require.NoError(b, err)
+	vars := map[string]interface{}{
+		"first": "Ada",
+		"last":  "Lovelace",
+		"items": []interface{}{"a", "b", "c"},
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := tmpl.Render(vars)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
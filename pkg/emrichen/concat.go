@@ -1,13 +1,12 @@
 package emrichen
 
 import (
-	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
 )
 
 func (ei *Interpreter) handleConcat(node *yaml.Node) (*yaml.Node, error) {
 	if node.Kind != yaml.SequenceNode {
-		return nil, errors.New("!Concat requires a sequence node")
+		return nil, tagArgumentErrorf("!Concat requires a sequence node")
 	}
 
 	concatenated := []*yaml.Node{}
@@ -21,7 +20,7 @@ func (ei *Interpreter) handleConcat(node *yaml.Node) (*yaml.Node, error) {
 			continue
 		}
 		if resolvedListItem.Kind != yaml.SequenceNode {
-			return nil, errors.New("!Concat items must be sequences")
+			return nil, tagArgumentErrorf("!Concat items must be sequences")
 		}
 		concatenated = append(concatenated, resolvedListItem.Content...)
 	}
@@ -0,0 +1,191 @@
+package emrichen
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/go-go-golems/go-emrichen/pkg/env"
+	"gopkg.in/yaml.v3"
+)
+
+// lazyDefault is a variable set by !Defaults. It is evaluated on first
+// access, in the scope of its !Defaults block, so that defaults can refer to
+// each other regardless of their order. Clones share lazyDefaults, which is
+// why the memoized value is protected by a mutex.
+type lazyDefault struct {
+	name string
+	node *yaml.Node
+	// frame is the frame defining the defaults of the block.
+	frame *env.Frame
+	// sourceFile is the file the block was read from, used to report errors.
+	sourceFile string
+	// tag is the tag declaring the default, !Defaults or !Params.
+	tag string
+
+	mu    sync.Mutex
+	value *yaml.Node
+	done  bool
+}
+
+// handleDefaults defines the entries of a !Defaults mapping as lazily
+// evaluated variables. Later !Defaults override earlier ones, but variables
+// supplied by the caller (WithVars, WithEnviron or Template.Render) are never
+// overridden.
+func (ei *Interpreter) handleDefaults(node *yaml.Node) (*yaml.Node, error) {
+	return nil, ei.defineDefaults(node, "!Defaults")
+}
+
+// defineDefaults defines the entries of a mapping as lazily evaluated
+// defaults, declared by tag.
+func (ei *Interpreter) defineDefaults(node *yaml.Node, tag string) error {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	var defaults []*lazyDefault
+	vars := map[string]interface{}{}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name := node.Content[i].Value
+		if ei.callerVars[name] {
+			continue
+		}
+		d := &lazyDefault{
+			name:       name,
+			node:       node.Content[i+1],
+			sourceFile: ei.sourceFile,
+			tag:        tag,
+		}
+		defaults = append(defaults, d)
+		vars[name] = d
+	}
+
+	ei.env.Push(vars)
+	frame := ei.env.GetCurrentFrame()
+	for _, d := range defaults {
+		d.frame = frame
+	}
+
+	return nil
+}
+
+// pushCallerVars pushes variables supplied by the caller, which take
+// precedence over defaults set by templates.
+func (ei *Interpreter) pushCallerVars(vars map[string]interface{}) {
+	ei.env.Push(vars)
+
+	// callerVars is shared with clones
+	callerVars := make(map[string]bool, len(ei.callerVars)+len(vars))
+	for name := range ei.callerVars {
+		callerVars[name] = true
+	}
+	for name := range vars {
+		callerVars[name] = true
+	}
+	ei.callerVars = callerVars
+}
+
+// resolveVariable is the env.Resolver of the interpreter, evaluating
+// variables set by !Defaults.
+func (ei *Interpreter) resolveVariable(v interface{}) (interface{}, error) {
+	d, ok := v.(*lazyDefault)
+	if !ok {
+		return v, nil
+	}
+	return ei.resolveDefault(d)
+}
+
+func (ei *Interpreter) resolveDefault(d *lazyDefault) (*yaml.Node, error) {
+	d.mu.Lock()
+	value, done := d.value, d.done
+	d.mu.Unlock()
+	if done {
+		return value, nil
+	}
+
+	for i, resolving := range ei.resolving {
+		if resolving == d {
+			chain := make([]string, 0, len(ei.resolving)-i+1)
+			for _, r := range ei.resolving[i:] {
+				chain = append(chain, r.name)
+			}
+			return nil, &DefaultsCycleError{Chain: append(chain, d.name)}
+		}
+	}
+	ei.resolving = append(ei.resolving, d)
+	previousEnv, previousSourceFile, previousMergeDepth := ei.env, ei.sourceFile, ei.mergeDepth
+	ei.env = env.NewEnv(env.WithResolver(ei.resolveVariable), env.WithFrame(d.frame))
+	ei.sourceFile = d.sourceFile
+	// the memoized value is shared by all uses, not only the !Merge item
+	// that triggered the evaluation
+	ei.mergeDepth = 0
+	defer func() {
+		ei.resolving = ei.resolving[:len(ei.resolving)-1]
+		ei.env, ei.sourceFile, ei.mergeDepth = previousEnv, previousSourceFile, previousMergeDepth
+	}()
+
+	err := ei.withAnchorScope(func() error {
+		var err error
+		value, err = ei.Process(d.node)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// another clone may have resolved the default concurrently, to the same
+	// value
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.done {
+		d.value, d.done = value, true
+	}
+	return d.value, nil
+}
+
+// Variable is a variable in scope, as returned by Interpreter.Variables.
+type Variable struct {
+	Name  string
+	Value *yaml.Node
+	// Default is true for variables set by !Defaults or !Params, Tag is then
+	// the declaring tag and Source the position of their value, formatted like
+	// Error.Position.
+	Default bool
+	Tag     string
+	Source  string
+}
+
+// Variables returns the variables currently in scope, sorted by name.
+// Defaults are evaluated, failing if any of them can't be.
+func (ei *Interpreter) Variables() ([]Variable, error) {
+	frame := ei.env.GetCurrentFrame()
+	if frame == nil {
+		return nil, nil
+	}
+
+	vars := frame.Variables()
+	ret := make([]Variable, 0, len(vars))
+	for name, v := range vars {
+		variable := Variable{Name: name}
+		if d, ok := v.(*lazyDefault); ok {
+			variable.Default = true
+			variable.Tag = d.tag
+			source := &Error{File: d.sourceFile, Line: d.node.Line, Column: d.node.Column}
+			variable.Source = source.Position()
+		}
+
+		resolved, err := ei.resolveVariable(v)
+		if err != nil {
+			return nil, err
+		}
+		variable.Value, err = ValueToNode(resolved)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, variable)
+	}
+
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret, nil
+}
@@ -1,6 +1,12 @@
 package emrichen
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
 
 func TestEmrichenDefaultsTag(t *testing.T) {
 	tests := []testCase{
@@ -56,8 +62,160 @@ var1: !Var var1`,
 var1: null`,
 			expectError: true, // If the behavior is to throw an error when the variable is not defined
 		},
+		{
+			name: "Defaults Referring To Later Defaults Test",
+			inputYAML: `
+!Defaults
+url: !Format "https://{host}:{port}"
+host: !Var name
+name: example.com
+port: 8080
+---
+url: !Var url
+host: !Lookup host`,
+			expected: `
+url: https://example.com:8080
+host: example.com`,
+		},
+		{
+			name: "Defaults Are Only Evaluated When Used Test",
+			inputYAML: `
+!Defaults
+unused: !Var missing
+used: value
+---
+used: !Var used`,
+			expected: `
+used: value`,
+		},
+		{
+			name: "Defaults Cycle Test",
+			inputYAML: `
+!Defaults
+a: !Var b
+b: !Format "{c}"
+c: !Lookup a
+---
+a: !Var a`,
+			expectError:        true,
+			expectErrorMessage: "defaults cycle: a -> b -> c -> a",
+		},
+		{
+			name: "Self Referencing Default Test",
+			inputYAML: `
+!Defaults
+a: !Var a
+---
+a: !Var a`,
+			expectError:        true,
+			expectErrorMessage: "defaults cycle: a -> a",
+		},
+		{
+			name: "Caller Variables Override Defaults Test",
+			inputYAML: `
+!Defaults
+var1: default1
+var2: !Format "{var1}-2"
+---
+var1: !Var var1
+var2: !Var var2`,
+			initVars: map[string]interface{}{"var1": "caller"},
+			expected: `
+var1: caller
+var2: caller-2`,
+		},
+		{
+			name: "Later Defaults Refer To Earlier Defaults Test",
+			inputYAML: `
+!Defaults
+base: one
+---
+!Defaults
+derived: !Format "{base}-two"
+---
+derived: !Var derived`,
+			expected: `
+derived: one-two`,
+		},
 		// Add more tests for Invalid Data Types, Nested Variables, Compatibility with Other Tags, etc.
 	}
 
 	runTests(t, tests)
 }
+
+func TestDefaultsAreEvaluatedOnce(t *testing.T) {
+	calls := 0
+	ei, err := NewInterpreter(WithAdditionalTags(TagFuncMap{
+		"!Count": func(ei *Interpreter, node *yaml.Node) (*yaml.Node, error) {
+			calls++
+			return makeInt(calls), nil
+		},
+	}))
+	require.NoError(t, err)
+
+	processToYAML(t, ei, "!Defaults\nn: !Count\n")
+	assert.Equal(t, 0, calls)
+	assert.Equal(t, "- 1\n- 1\n- \"1\"\n", processToYAML(t, ei, `[!Var n, !Lookup n, !Format "{n}"]`))
+	assert.Equal(t, 1, calls)
+}
+
+func TestDefaultsCycleError(t *testing.T) {
+	ei, err := NewInterpreter()
+	require.NoError(t, err)
+	processToYAML(t, ei, "!Defaults\na: !Var b\nb: !Var a\n")
+
+	_, err = ei.Process(parseNode(t, "x: !Var b"))
+	require.ErrorIs(t, err, ErrDefaultsCycle)
+	var cycleErr *DefaultsCycleError
+	require.ErrorAs(t, err, &cycleErr)
+	assert.Equal(t, []string{"b", "a", "b"}, cycleErr.Chain)
+
+	// the interpreter can still be used afterwards
+	processToYAML(t, ei, "!Defaults\nc: ok\n")
+	assert.Equal(t, "ok\n", processToYAML(t, ei, "!Var c"))
+}
+
+func TestInterpreterVariables(t *testing.T) {
+	ei, err := NewInterpreter(WithVars(map[string]interface{}{"a": "caller", "list": []interface{}{1}}))
+	require.NoError(t, err)
+	ei.SetSourceFile("defaults.yml")
+	processToYAML(t, ei, "!Defaults\na: default\nb: !Format \"{a}-b\"\n")
+
+	variables, err := ei.Variables()
+	require.NoError(t, err)
+	require.Len(t, variables, 3)
+
+	assert.Equal(t, "a", variables[0].Name)
+	assert.False(t, variables[0].Default)
+	assert.Equal(t, "caller", variables[0].Value.Value)
+
+	assert.Equal(t, "b", variables[1].Name)
+	assert.True(t, variables[1].Default)
+	assert.Equal(t, "!Defaults", variables[1].Tag)
+	assert.Equal(t, "defaults.yml:3:4", variables[1].Source)
+	assert.Equal(t, "caller-b", variables[1].Value.Value)
+
+	assert.Equal(t, "list", variables[2].Name)
+	assert.Equal(t, yaml.SequenceNode, variables[2].Value.Kind)
+}
+
+func TestInterpreterVariablesParams(t *testing.T) {
+	ei, err := NewInterpreter(WithVars(map[string]interface{}{"replicas": "3"}))
+	require.NoError(t, err)
+	ei.SetSourceFile("params.yml")
+	processToYAML(t, ei, "!Params\nreplicas: {type: int, default: 1}\nname: {default: app}\n")
+
+	variables, err := ei.Variables()
+	require.NoError(t, err)
+	require.Len(t, variables, 2)
+
+	assert.Equal(t, "name", variables[0].Name)
+	assert.True(t, variables[0].Default)
+	assert.Equal(t, "!Params", variables[0].Tag)
+	assert.Equal(t, "params.yml:3:17", variables[0].Source)
+
+	// the supplied value, converted to the declared type
+	assert.Equal(t, "replicas", variables[1].Name)
+	assert.False(t, variables[1].Default)
+	assert.Equal(t, "!!int", variables[1].Value.ShortTag())
+}
@@ -0,0 +1,80 @@
+package emrichen
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// documentsTag marks the sequence returned by `!Loop` with `as_documents`,
+// whose items are emitted as separate YAML documents.
+const documentsTag = "!emrichen/documents"
+
+// ErrMultipleDocuments is returned by the single-document decoder helpers
+// when a document expands into several documents.
+var ErrMultipleDocuments = errors.New("document expands into multiple documents")
+
+func isDocuments(node *yaml.Node) bool {
+	return node != nil && node.Kind == yaml.SequenceNode && node.Tag == documentsTag
+}
+
+// checkNotDocuments returns an error if node expands into multiple documents,
+// which is only allowed at the root of a document.
+func checkNotDocuments(node *yaml.Node) error {
+	if isDocuments(node) {
+		return tagArgumentErrorf("!Loop 'as_documents' can only be used at the document level")
+	}
+	return nil
+}
+
+// ProcessDocuments processes a single input document and returns the output
+// documents it expands into. A document used to set !Defaults or evaluating
+// to !Void results in no documents, a `!Loop` with `as_documents` results in
+// one document per iteration.
+func (ei *Interpreter) ProcessDocuments(node *yaml.Node) ([]*yaml.Node, error) {
+	if ei.state == nil {
+		return ei.ProcessDocumentsContext(context.Background(), node)
+	}
+
+	var resolved *yaml.Node
+	err := ei.withAnchorScope(func() error {
+		var err error
+		resolved, err = ei.Process(node)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if resolved == nil {
+		return []*yaml.Node{}, nil
+	}
+	if isDocuments(resolved) {
+		return resolved.Content, nil
+	}
+	return []*yaml.Node{resolved}, nil
+}
+
+type documentsInterpretHelper struct {
+	target      *[]*yaml.Node
+	interpreter *Interpreter
+}
+
+func (ei *documentsInterpretHelper) UnmarshalYAML(value *yaml.Node) error {
+	documents, err := ei.interpreter.ProcessDocuments(value)
+	if err != nil {
+		return err
+	}
+	*ei.target = append(*ei.target, documents...)
+	return nil
+}
+
+// CreateDocumentsDecoder returns a decoder helper appending the output
+// documents of each decoded input document to target.
+func (ei *Interpreter) CreateDocumentsDecoder(target *[]*yaml.Node) *documentsInterpretHelper {
+	return &documentsInterpretHelper{
+		target:      target,
+		interpreter: ei,
+	}
+}
@@ -1,6 +1,7 @@
 package emrichen
 
 import (
+	"context"
 	"crypto/md5"  // #nosec G501
 	"crypto/sha1" // #nosec G505
 	"crypto/sha256"
@@ -16,21 +17,75 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+// Interpreter processes YAML nodes containing Emrichen tags. It keeps the
+// variables in scope while processing, and is not safe for concurrent use, see
+// Clone.
 type Interpreter struct {
 	env            *env.Env
 	additionalTags map[string]TagFunc
 	funcmaps       []template.FuncMap
+	// sourceFile is the file currently being processed, used to report error positions.
+	sourceFile string
+	// anchors maps the anchored nodes of the current document to their processed result.
+	anchors map[*yaml.Node]*yaml.Node
+	// fs is the filesystem from which the !Include* tags read files.
+	fs fileSystem
+	// includePaths are searched for included files not found relative to the including file.
+	includePaths []string
+	limits       Limits
+	// sandbox restricts what templates can access, if set.
+	sandbox *Sandbox
+	formats *formatCache
+	// state tracks cancellation and resource usage of the current call to ProcessContext.
+	state *renderState
+	// callerVars are the names of the variables supplied by the caller, which
+	// !Defaults don't override.
+	callerVars map[string]bool
+	// resolving is the chain of defaults being evaluated, to detect cycles.
+	resolving []*lazyDefault
+	// mergeDepth counts the !Merge tags being processed, outside of which
+	// !Delete is an error.
+	mergeDepth int
 }
 
 type InterpreterOption func(*Interpreter) error
 
 func WithVars(vars map[string]interface{}) InterpreterOption {
 	return func(ei *Interpreter) error {
-		ei.env.Push(vars)
+		ei.pushCallerVars(vars)
 		return nil
 	}
 }
 
+// WithEnviron exposes environment variables, given in the KEY=value form
+// returned by os.Environ, to templates. The variables are stored as a mapping
+// under namespace, so that with namespace "env" a template reads `!Lookup env.HOME`.
+// An empty namespace puts each variable at the top level, readable with `!Var HOME`.
+func WithEnviron(namespace string, environ []string) InterpreterOption {
+	return func(ei *Interpreter) error {
+		vars := EnvironToMap(environ)
+		if namespace != "" {
+			vars = map[string]interface{}{namespace: vars}
+		}
+		ei.pushCallerVars(vars)
+		return nil
+	}
+}
+
+// EnvironToMap converts a list of KEY=value strings into a map.
+// Entries without a '=' are mapped to an empty string.
+func EnvironToMap(environ []string) map[string]interface{} {
+	ret := make(map[string]interface{}, len(environ))
+	for _, kv := range environ {
+		k, v, _ := strings.Cut(kv, "=")
+		if k == "" {
+			continue
+		}
+		ret[k] = v
+	}
+	return ret
+}
+
 func WithFuncMap(funcmap ...template.FuncMap) InterpreterOption {
 	return func(ei *Interpreter) error {
 		ei.funcmaps = append(ei.funcmaps, funcmap...)
@@ -43,13 +98,10 @@ type TagFuncMap map[string]TagFunc
 
 var defaultHandlers = TagFuncMap{
 	"!Defaults": func(ei *Interpreter, node *yaml.Node) (*yaml.Node, error) {
-		if node.Kind == yaml.MappingNode {
-			err := ei.updateVars(node.Content)
-			if err != nil {
-				return nil, err
-			}
-		}
-		return nil, nil
+		return ei.handleDefaults(node)
+	},
+	"!Params": func(ei *Interpreter, node *yaml.Node) (*yaml.Node, error) {
+		return ei.handleParams(node)
 	},
 	"!All": func(ei *Interpreter, node *yaml.Node) (*yaml.Node, error) {
 		return ei.handleAll(node)
@@ -65,7 +117,7 @@ var defaultHandlers = TagFuncMap{
 	},
 	"!Base64": func(ei *Interpreter, node *yaml.Node) (*yaml.Node, error) {
 		if node.Kind != yaml.ScalarNode {
-			return nil, errors.New("!Base64 requires a scalar value")
+			return nil, tagArgumentErrorf("!Base64 requires a scalar value")
 		}
 		return makeString(base64.StdEncoding.EncodeToString([]byte(node.Value))), nil
 	},
@@ -73,20 +125,21 @@ var defaultHandlers = TagFuncMap{
 		return ei.handleConcat(node)
 	},
 	"!Debug": func(ei *Interpreter, node *yaml.Node) (*yaml.Node, error) {
-		// need to remove debug tag
+		// need to remove debug tag, without modifying the template
+		untagged := *node
 		switch node.Kind {
 		case yaml.SequenceNode:
-			node.Tag = "!!seq"
+			untagged.Tag = "!!seq"
 		case yaml.MappingNode:
-			node.Tag = "!!map"
+			untagged.Tag = "!!map"
 		case yaml.ScalarNode:
-			node.Tag = "!!str"
+			untagged.Tag = "!!str"
 		case yaml.DocumentNode:
-			node.Tag = "!!doc"
+			untagged.Tag = "!!doc"
 		case yaml.AliasNode:
-			node.Tag = "!!alias"
+			untagged.Tag = "!!alias"
 		}
-		v, err := ei.Process(node)
+		v, err := ei.Process(&untagged)
 		if err != nil {
 			return nil, err
 		}
@@ -94,9 +147,12 @@ var defaultHandlers = TagFuncMap{
 		fmt.Printf("DEBUG: %s\n", toInterface)
 		return v, nil
 	},
+	"!Delete": func(ei *Interpreter, node *yaml.Node) (*yaml.Node, error) {
+		return ei.handleDelete(node)
+	},
 	"!Error": func(ei *Interpreter, node *yaml.Node) (*yaml.Node, error) {
 		if node.Kind != yaml.ScalarNode {
-			return nil, errors.New("!Error tag requires a scalar value for the error message")
+			return nil, tagArgumentErrorf("!Error tag requires a scalar value for the error message")
 		}
 		errorString, err := ei.renderFormatString(node.Value)
 		if err != nil {
@@ -174,7 +230,7 @@ var defaultHandlers = TagFuncMap{
 	},
 	"!MD5": func(ei *Interpreter, node *yaml.Node) (*yaml.Node, error) {
 		if node.Kind != yaml.ScalarNode {
-			return nil, errors.New("!MD5 requires a scalar value")
+			return nil, tagArgumentErrorf("!MD5 requires a scalar value")
 		}
 		hash := md5.Sum([]byte(node.Value)) // #nosec G401
 		return makeString(hex.EncodeToString(hash[:])), nil
@@ -190,14 +246,14 @@ var defaultHandlers = TagFuncMap{
 	},
 	"!SHA1": func(ei *Interpreter, node *yaml.Node) (*yaml.Node, error) {
 		if node.Kind != yaml.ScalarNode {
-			return nil, errors.New("!SHA1 requires a scalar value")
+			return nil, tagArgumentErrorf("!SHA1 requires a scalar value")
 		}
 		hash := sha1.Sum([]byte(node.Value)) // #nosec G401
 		return makeString(hex.EncodeToString(hash[:])), nil
 	},
 	"!SHA256": func(ei *Interpreter, node *yaml.Node) (*yaml.Node, error) {
 		if node.Kind != yaml.ScalarNode {
-			return nil, errors.New("!SHA256 requires a scalar value")
+			return nil, tagArgumentErrorf("!SHA256 requires a scalar value")
 		}
 		hash := sha256.Sum256([]byte(node.Value))
 		return makeString(hex.EncodeToString(hash[:])), nil
@@ -216,6 +272,22 @@ var defaultHandlers = TagFuncMap{
 	},
 }
 
+// WithIncludePaths adds directories in which included files are looked up
+// when they are not found relative to the including file.
+func WithIncludePaths(paths ...string) InterpreterOption {
+	return func(ei *Interpreter) error {
+		ei.includePaths = append(ei.includePaths, paths...)
+		return nil
+	}
+}
+
+// SetSourceFile sets the file from which the next documents are read. Relative
+// include paths are resolved against its directory, and it is reported in the
+// position of errors.
+func (ei *Interpreter) SetSourceFile(filePath string) {
+	ei.sourceFile = filePath
+}
+
 func WithAdditionalTags(tags TagFuncMap) InterpreterOption {
 	return func(ei *Interpreter) error {
 		for k, v := range tags {
@@ -230,9 +302,12 @@ func WithAdditionalTags(tags TagFuncMap) InterpreterOption {
 
 func NewInterpreter(options ...InterpreterOption) (*Interpreter, error) {
 	ret := &Interpreter{
-		env:            env.NewEnv(),
 		additionalTags: map[string]TagFunc{},
+		fs:             osFileSystem{},
+		formats:        &formatCache{},
+		limits:         DefaultLimits,
 	}
+	ret.env = env.NewEnv(env.WithResolver(ret.resolveVariable))
 
 	// Copy default handlers
 	for k, v := range defaultHandlers {
@@ -246,9 +321,38 @@ func NewInterpreter(options ...InterpreterOption) (*Interpreter, error) {
 		}
 	}
 
+	err := ret.applySandbox()
+	if err != nil {
+		return nil, err
+	}
+
 	return ret, nil
 }
 
+// Clone returns an interpreter sharing the configuration of ei (tags,
+// template functions, filesystem, limits and sandbox), with its own copy of
+// the variables currently in scope and of the registered tags. Variables set
+// and tags registered by either interpreter afterwards, for example with
+// !Defaults or RegisterTag, are not visible to the other.
+//
+// An Interpreter is not safe for concurrent use: clone it for each goroutine
+// instead. Clone itself may be called concurrently, as long as ei is not
+// processing.
+func (ei *Interpreter) Clone() *Interpreter {
+	ret := *ei
+	ret.additionalTags = make(map[string]TagFunc, len(ei.additionalTags))
+	for tag, f := range ei.additionalTags {
+		ret.additionalTags[tag] = f
+	}
+	ret.env = ei.env.Fork()
+	ret.env.SetResolver(ret.resolveVariable)
+	ret.anchors = nil
+	ret.state = nil
+	ret.resolving = nil
+	ret.mergeDepth = 0
+	return &ret
+}
+
 type interpretHelper struct {
 	target      interface{}
 	interpreter *Interpreter
@@ -259,28 +363,58 @@ type rawInterpretHelper struct {
 	interpreter *Interpreter
 }
 
+// withAnchorScope runs f with an empty anchor cache, since anchors are only
+// valid within a single document.
+func (ei *Interpreter) withAnchorScope(f func() error) error {
+	previousAnchors := ei.anchors
+	ei.anchors = nil
+	defer func() {
+		ei.anchors = previousAnchors
+	}()
+	return f()
+}
+
 func (ei *interpretHelper) UnmarshalYAML(value *yaml.Node) error {
-	resolved, err := ei.interpreter.Process(value)
+	var resolved *yaml.Node
+	err := ei.interpreter.withAnchorScope(func() error {
+		var err error
+		resolved, err = ei.interpreter.Process(value)
+		return err
+	})
 	if err != nil {
 		return err
 	}
 	if resolved == nil {
 		return nil
 	}
+	if isDocuments(resolved) {
+		return ErrMultipleDocuments
+	}
 	return resolved.Decode(ei.target)
 }
 
 func (ei *rawInterpretHelper) UnmarshalYAML(value *yaml.Node) error {
-	resolved, err := ei.interpreter.Process(value)
+	var resolved *yaml.Node
+	err := ei.interpreter.withAnchorScope(func() error {
+		var err error
+		resolved, err = ei.interpreter.Process(value)
+		return err
+	})
 	if err != nil {
 		return err
 	}
+	if isDocuments(resolved) {
+		return ErrMultipleDocuments
+	}
 	if resolved != nil {
 		*ei.target = *resolved
 	}
 	return nil
 }
 
+// CreateDecoder returns a decoder helper processing a document and decoding
+// the result into target. It returns ErrMultipleDocuments for documents
+// expanding into several documents, use CreateDocumentsDecoder to handle them.
 func (ei *Interpreter) CreateDecoder(target interface{}) *interpretHelper {
 	return &interpretHelper{
 		target:      target,
@@ -288,6 +422,7 @@ func (ei *Interpreter) CreateDecoder(target interface{}) *interpretHelper {
 	}
 }
 
+// CreateRawDecoder is like CreateDecoder, but stores the processed node.
 func (ei *Interpreter) CreateRawDecoder(target *yaml.Node) *rawInterpretHelper {
 	return &rawInterpretHelper{
 		target:      target,
@@ -331,6 +466,16 @@ func (ei *Interpreter) LookupAll(jsonPath string) (*yaml.Node, error) {
 }
 
 func (ei *Interpreter) Process(node *yaml.Node) (*yaml.Node, error) {
+	if ei.state == nil {
+		return ei.ProcessContext(context.Background(), node)
+	}
+
+	leave, err := ei.enterNode()
+	if err != nil {
+		return nil, ei.wrapError(err, "", node)
+	}
+	defer leave()
+
 	tag := node.Tag
 	ss := strings.Split(tag, ",")
 	if len(ss) == 0 {
@@ -349,10 +494,14 @@ func (ei *Interpreter) Process(node *yaml.Node) (*yaml.Node, error) {
 		ss[i], ss[opp] = ss[opp], ss[i]
 	}
 
+	source := node
 	for _, verb := range ss {
 		ret, err := func() (*yaml.Node, error) {
 			// we allow overriding our own tags
 			if f, ok := ei.additionalTags[verb]; ok {
+				if err := ei.checkTagAllowed(verb); err != nil {
+					return nil, err
+				}
 				return f(ei, node)
 			}
 
@@ -368,6 +517,9 @@ func (ei *Interpreter) Process(node *yaml.Node) (*yaml.Node, error) {
 					if v == nil {
 						continue
 					}
+					if err := checkNotDocuments(v); err != nil {
+						return nil, ei.wrapError(err, "", node.Content[i])
+					}
 					retContent = append(retContent, v)
 				}
 				return &yaml.Node{
@@ -376,29 +528,11 @@ func (ei *Interpreter) Process(node *yaml.Node) (*yaml.Node, error) {
 					Tag:     "!!seq",
 				}, nil
 			case yaml.MappingNode:
-				retContent := make([]*yaml.Node, 0)
-				for i := 0; i < len(node.Content); i += 2 {
-					key := node.Content[i]
-					value := node.Content[i+1]
-
-					v, err := ei.Process(value)
-					if err != nil {
-						return nil, err
-					}
-					if v == nil {
-						continue
-					}
-					retContent = append(retContent, key, v)
-				}
-				return &yaml.Node{
-					Kind:    yaml.MappingNode,
-					Content: retContent,
-					Tag:     "!!map",
-				}, nil
+				return ei.processMapping(node)
 			case yaml.ScalarNode:
 				return node, nil
 			case yaml.AliasNode:
-				return nil, errors.New("alias nodes are not supported")
+				return ei.processAlias(node)
 			case yaml.DocumentNode:
 				if len(node.Content) == 1 {
 					return ei.Process(node.Content[0])
@@ -410,12 +544,20 @@ func (ei *Interpreter) Process(node *yaml.Node) (*yaml.Node, error) {
 		}()
 
 		if err != nil {
-			return nil, err
+			tag := ""
+			if _, ok := ei.additionalTags[verb]; ok {
+				tag = verb
+			}
+			return nil, ei.wrapError(err, tag, source)
 		}
 
 		node = ret
 	}
 
+	if source.Anchor != "" {
+		node = ei.recordAnchor(source, node)
+	}
+
 	return node, nil
 }
 
@@ -431,11 +573,7 @@ func (ei *Interpreter) updateVars(content []*yaml.Node) error {
 		if err != nil {
 			return err
 		}
-		v, ok := NodeToInterface(node)
-		if !ok {
-			return errors.New("could not get node value")
-		}
-		vars[name] = v
+		vars[name] = node
 	}
 
 	ei.env.Push(vars)
@@ -0,0 +1,56 @@
+package emrichen
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// benchmarkLoop renders a loop over items items, with vars variables in scope.
+func benchmarkLoop(b *testing.B, items int, vars int, template string) {
+	defaults := make(map[string]interface{}, vars)
+	for i := 0; i < vars; i++ {
+		defaults[fmt.Sprintf("var%d", i)] = i
+	}
+	values := make([]interface{}, items)
+	for i := range values {
+		values[i] = i
+	}
+	defaults["values"] = values
+
+	input := "!Loop\nover: !Var values\ntemplate: " + template + "\n"
+	node := &yaml.Node{}
+	if err := yaml.NewDecoder(strings.NewReader(input)).Decode(node); err != nil {
+		b.Fatal(err)
+	}
+
+	ei, err := NewInterpreter(WithVars(defaults))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ei.Process(node); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLoopVar(b *testing.B) {
+	for _, vars := range []int{10, 1000, 10000} {
+		b.Run(fmt.Sprintf("vars=%d", vars), func(b *testing.B) {
+			benchmarkLoop(b, 1000, vars, "!Var item")
+		})
+	}
+}
+
+func BenchmarkLoopFormat(b *testing.B) {
+	for _, vars := range []int{10, 1000, 10000} {
+		b.Run(fmt.Sprintf("vars=%d", vars), func(b *testing.B) {
+			benchmarkLoop(b, 1000, vars, `!Format "{item}-{var1}"`)
+		})
+	}
+}
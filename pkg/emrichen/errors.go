@@ -0,0 +1,240 @@
+package emrichen
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-go-golems/go-emrichen/pkg/env"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// ErrVariableNotFound is matched (using errors.Is) when a template
+	// references an undefined variable, e.g. with !Var.
+	ErrVariableNotFound = env.ErrVariableNotFound
+	// ErrPathNotFound is matched when a jsonpath expression, e.g. in !Lookup,
+	// does not resolve against the current variables.
+	ErrPathNotFound = env.ErrPathNotFound
+	// ErrInvalidPath is matched when a jsonpath expression is malformed.
+	ErrInvalidPath = env.ErrInvalidPath
+	// ErrTagArgument is matched when a tag is given arguments it can't handle,
+	// such as a node of the wrong kind or a missing required key.
+	ErrTagArgument = errors.New("invalid tag argument")
+	// ErrLimitExceeded is matched when processing exceeds one of the limits
+	// configured with WithLimits.
+	ErrLimitExceeded = errors.New("limit exceeded")
+	// ErrIncludeCycle is matched when a file includes itself, directly or
+	// through other files.
+	ErrIncludeCycle = errors.New("include cycle")
+	// ErrDefaultsCycle is matched when a variable set by !Defaults refers to
+	// itself, directly or through other defaults.
+	ErrDefaultsCycle = errors.New("defaults cycle")
+	// ErrInvalidParams is matched when the variables don't match the
+	// parameters declared with !Params.
+	ErrInvalidParams = errors.New("invalid parameters")
+	// ErrSandboxViolation is matched when a template does something the
+	// sandbox configured with WithSandbox doesn't allow.
+	ErrSandboxViolation = errors.New("sandbox violation")
+)
+
+// TagArgumentError is returned by tag handlers when their arguments are invalid.
+// It matches ErrTagArgument.
+type TagArgumentError struct {
+	Message string
+}
+
+func (e *TagArgumentError) Error() string {
+	return e.Message
+}
+
+func (e *TagArgumentError) Is(target error) bool {
+	return target == ErrTagArgument
+}
+
+// tagArgumentErrorf creates a *TagArgumentError with a formatted message.
+func tagArgumentErrorf(format string, args ...interface{}) error {
+	return &TagArgumentError{Message: fmt.Sprintf(format, args...)}
+}
+
+// LimitError is returned when processing exceeds one of the configured
+// Limits. It matches ErrLimitExceeded.
+type LimitError struct {
+	// Limit is the name of the exceeded limit, e.g. LimitDepth.
+	Limit Limit
+	// Max is the configured value of the limit.
+	Max int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s limit of %d exceeded", e.Limit, e.Max)
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// IncludeCycleError is returned when a file includes itself. It matches
+// ErrIncludeCycle.
+type IncludeCycleError struct {
+	// Chain is the chain of included files, starting with the outermost file
+	// and ending with the file included again.
+	Chain []string
+}
+
+func (e *IncludeCycleError) Error() string {
+	return "include cycle: " + strings.Join(e.Chain, " -> ")
+}
+
+func (e *IncludeCycleError) Is(target error) bool {
+	return target == ErrIncludeCycle
+}
+
+// DefaultsCycleError is returned when evaluating a variable set by !Defaults
+// requires its own value. It matches ErrDefaultsCycle.
+type DefaultsCycleError struct {
+	// Chain is the chain of variables being evaluated, starting and ending
+	// with the variable referring to itself.
+	Chain []string
+}
+
+func (e *DefaultsCycleError) Error() string {
+	return "defaults cycle: " + strings.Join(e.Chain, " -> ")
+}
+
+func (e *DefaultsCycleError) Is(target error) bool {
+	return target == ErrDefaultsCycle
+}
+
+// ParamsError is returned when the variables don't match the parameters
+// declared with !Params. It matches ErrInvalidParams.
+type ParamsError struct {
+	// Problems lists every missing or invalid parameter.
+	Problems []string
+}
+
+func (e *ParamsError) Error() string {
+	return "invalid parameters: " + strings.Join(e.Problems, "; ")
+}
+
+func (e *ParamsError) Is(target error) bool {
+	return target == ErrInvalidParams
+}
+
+// SandboxError is returned when a template violates the sandbox. It matches
+// ErrSandboxViolation.
+type SandboxError struct {
+	Message string
+}
+
+func (e *SandboxError) Error() string {
+	return e.Message
+}
+
+func (e *SandboxError) Is(target error) bool {
+	return target == ErrSandboxViolation
+}
+
+func sandboxErrorf(format string, args ...interface{}) error {
+	return &SandboxError{Message: fmt.Sprintf(format, args...)}
+}
+
+// Error is returned by Interpreter.Process when processing a node fails.
+// It records where in the source the failing node was found, as well as the
+// chain of tags that were being evaluated when the error occurred.
+//
+// Error() renders the error compiler-style, e.g.:
+//
+//	deployment.yml:42:7: !Var: variable foo not found
+//
+// Use errors.As to retrieve it, and Unwrap (or errors.Is) to get at the
+// underlying cause.
+type Error struct {
+	// File is the source file of the failing node. It is empty when the
+	// source file is not known to the interpreter.
+	File string
+	// Line and Column are the 1-based position of the failing node.
+	// They are 0 for nodes that were generated during processing.
+	Line   int
+	Column int
+	// Tags is the chain of enclosing tags, outermost first.
+	Tags []string
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *Error) Error() string {
+	var sb strings.Builder
+	if pos := e.Position(); pos != "" {
+		sb.WriteString(pos)
+		sb.WriteString(": ")
+	}
+
+	msg := e.Err.Error()
+	if len(e.Tags) > 0 {
+		// handlers often already mention their own tag
+		tag := e.Tags[len(e.Tags)-1]
+		if !strings.HasPrefix(msg, tag) {
+			sb.WriteString(tag)
+			sb.WriteString(": ")
+		}
+	}
+	sb.WriteString(msg)
+
+	return sb.String()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Position returns the location of the error as file:line:column, leaving
+// out the parts that are unknown.
+func (e *Error) Position() string {
+	switch {
+	case e.Line > 0 && e.File != "":
+		return fmt.Sprintf("%s:%d:%d", e.File, e.Line, e.Column)
+	case e.Line > 0:
+		return fmt.Sprintf("%d:%d", e.Line, e.Column)
+	default:
+		return e.File
+	}
+}
+
+// Breadcrumb returns the chain of enclosing tags, e.g. "!Loop > !If > !Var".
+func (e *Error) Breadcrumb() string {
+	return strings.Join(e.Tags, " > ")
+}
+
+// nodePosition returns the position of node in the current source file,
+// formatted like Error.Position.
+func (ei *Interpreter) nodePosition(node *yaml.Node) string {
+	e := &Error{File: ei.sourceFile, Line: node.Line, Column: node.Column}
+	return e.Position()
+}
+
+// wrapError attaches source information to an error returned while
+// processing node. If err already carries a position (because it was
+// raised by a nested node), only the enclosing tag is added to its
+// breadcrumb, so that the position keeps pointing at the innermost node.
+func (ei *Interpreter) wrapError(err error, tag string, node *yaml.Node) error {
+	if e, ok := err.(*Error); ok {
+		if tag != "" {
+			e.Tags = append([]string{tag}, e.Tags...)
+		}
+		return e
+	}
+
+	ret := &Error{
+		File: ei.sourceFile,
+		Err:  err,
+	}
+	if node != nil {
+		ret.Line = node.Line
+		ret.Column = node.Column
+	}
+	if tag != "" {
+		ret.Tags = []string{tag}
+	}
+	return ret
+}
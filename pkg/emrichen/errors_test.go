@@ -0,0 +1,129 @@
+package emrichen
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestErrorPosition(t *testing.T) {
+	tests := []struct {
+		name               string
+		inputYAML          string
+		expectedLine       int
+		expectedColumn     int
+		expectedBreadcrumb string
+		expectedError      string
+	}{
+		{
+			name:               "Missing variable at top level",
+			inputYAML:          "!Var foo",
+			expectedLine:       1,
+			expectedColumn:     1,
+			expectedBreadcrumb: "!Var",
+			expectedError:      "1:1: !Var: variable foo not found",
+		},
+		{
+			name: "Missing variable nested in mapping",
+			inputYAML: `
+a: 1
+b:
+  c: !Var foo`,
+			expectedLine:       4,
+			expectedColumn:     6,
+			expectedBreadcrumb: "!Var",
+			expectedError:      "4:6: !Var: variable foo not found",
+		},
+		{
+			name: "Breadcrumb through enclosing tags",
+			inputYAML: `
+items: !Loop
+  over: [1, 2]
+  template: !If
+    test: true
+    then: !Var foo`,
+			expectedLine:       6,
+			expectedColumn:     11,
+			expectedBreadcrumb: "!Loop > !If > !Var",
+			expectedError:      "6:11: !Var: variable foo not found",
+		},
+		{
+			name: "Handler message already mentioning its tag",
+			inputYAML: `
+!Loop
+  over: 1
+  template: foo`,
+			expectedLine:       2,
+			expectedColumn:     1,
+			expectedBreadcrumb: "!Loop",
+			expectedError:      "2:1: !Loop 'over' must be a sequence or mapping node",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ei, err := NewInterpreter()
+			require.NoError(t, err)
+
+			var result interface{}
+			err = yaml.Unmarshal([]byte(tc.inputYAML), ei.CreateDecoder(&result))
+			require.Error(t, err)
+
+			var emrichenErr *Error
+			require.True(t, errors.As(err, &emrichenErr))
+			assert.Equal(t, tc.expectedLine, emrichenErr.Line)
+			assert.Equal(t, tc.expectedColumn, emrichenErr.Column)
+			assert.Equal(t, tc.expectedBreadcrumb, emrichenErr.Breadcrumb())
+			assert.Equal(t, tc.expectedError, err.Error())
+		})
+	}
+}
+
+func TestErrorPositionInIncludedFile(t *testing.T) {
+	ei, err := NewInterpreter()
+	require.NoError(t, err)
+
+	var result interface{}
+	err = yaml.Unmarshal([]byte("foo: !Include test-data/missing-var.yml"), ei.CreateDecoder(&result))
+	require.Error(t, err)
+
+	var emrichenErr *Error
+	require.True(t, errors.As(err, &emrichenErr))
+	assert.Equal(t, "test-data/missing-var.yml", emrichenErr.File)
+	assert.Equal(t, "test-data/missing-var.yml:2:10: !Var: variable doesNotExist not found", err.Error())
+	assert.Equal(t, "!Include > !Var", emrichenErr.Breadcrumb())
+}
+
+func TestSentinelErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		inputYAML string
+		expected  error
+	}{
+		{name: "Undefined variable", inputYAML: "!Var missing", expected: ErrVariableNotFound},
+		{name: "Lookup of missing path", inputYAML: "!Lookup foo.missing", expected: ErrPathNotFound},
+		{name: "Lookup of malformed path", inputYAML: "!Lookup foo[", expected: ErrInvalidPath},
+		{name: "Exists with malformed path", inputYAML: "!Exists foo[", expected: ErrInvalidPath},
+		{name: "Unknown tag argument", inputYAML: "!Loop {over: [1], template: 1, unknown: 2}", expected: ErrTagArgument},
+		{name: "Missing tag argument", inputYAML: "!Loop {template: 1}", expected: ErrTagArgument},
+		{name: "Wrong node kind", inputYAML: "!Concat foo", expected: ErrTagArgument},
+		{name: "Template exists with malformed path", inputYAML: `!Format '{{ exists "foo[" }}'`, expected: ErrInvalidPath},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ei, err := NewInterpreter(WithVars(map[string]interface{}{
+				"foo": map[string]interface{}{"bar": "baz"},
+			}))
+			require.NoError(t, err)
+
+			var result interface{}
+			err = yaml.Unmarshal([]byte(tc.inputYAML), ei.CreateDecoder(&result))
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, tc.expected), "unexpected error: %v", err)
+		})
+	}
+}
@@ -1,17 +1,15 @@
 package emrichen
 
 import (
+	"github.com/go-go-golems/go-emrichen/pkg/env"
+	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
-	"strings"
 )
 
 func (ei *Interpreter) handleExists(node *yaml.Node) (*yaml.Node, error) {
 	v, err := ei.env.LookupAll("$."+node.Value, true)
 	if err != nil {
-		if strings.Contains(err.Error(), "unrecognized identifier ") {
-			return makeBool(false), nil
-		}
-		if strings.Contains(err.Error(), "array index out of bounds") {
+		if errors.Is(err, env.ErrPathNotFound) {
 			return makeBool(false), nil
 		}
 		return nil, err
@@ -1,27 +1,26 @@
 package emrichen
 
-import (
-	"github.com/pkg/errors"
-	"gopkg.in/yaml.v3"
-)
+import "gopkg.in/yaml.v3"
+
+var filterArgs = []ParsedVariable{
+	{Name: "over", Required: true, Expand: true},
+	{Name: "test"},
+	{Name: "as"},
+}
 
 func (ei *Interpreter) handleFilter(node *yaml.Node) (*yaml.Node, error) {
 	if node.Kind != yaml.MappingNode {
-		return nil, errors.New("!Filter requires a mapping node")
+		return nil, tagArgumentErrorf("!Filter requires a mapping node")
 	}
 
-	args, err := ei.ParseArgs(node, []ParsedVariable{
-		{Name: "over", Required: true, Expand: true},
-		{Name: "test"},
-		{Name: "as"},
-	})
+	args, err := ei.ParseArgs(node, filterArgs)
 	if err != nil {
 		return nil, err
 	}
 
 	overNode := args["over"]
 	if overNode.Kind != yaml.SequenceNode && overNode.Kind != yaml.MappingNode {
-		return nil, errors.New("!Filter 'over' argument must be a sequence or mapping")
+		return nil, tagArgumentErrorf("!Filter 'over' argument must be a sequence or mapping")
 	}
 	testNode, hasTestNode := args["test"]
 
@@ -29,7 +28,7 @@ func (ei *Interpreter) handleFilter(node *yaml.Node) (*yaml.Node, error) {
 	asNode, ok := args["as"]
 	if ok {
 		if asNode.Kind != yaml.ScalarNode {
-			return nil, errors.New("!Filter 'as' argument must be a scalar")
+			return nil, tagArgumentErrorf("!Filter 'as' argument must be a scalar")
 		}
 		varName = asNode.Value
 	}
@@ -57,12 +56,8 @@ func (ei *Interpreter) handleFilter(node *yaml.Node) (*yaml.Node, error) {
 
 		var result *yaml.Node
 		if hasTestNode {
-			v, ok := NodeToInterface(item)
-			if !ok {
-				return nil, errors.Errorf("could not get value for node: %v", item)
-			}
 			ei.env.Push(map[string]interface{}{
-				varName: v,
+				varName: item,
 			})
 			result, err = ei.Process(testNode)
 			ei.env.Pop()
@@ -3,11 +3,14 @@ package emrichen
 import (
 	"bytes"
 	"fmt"
+	"github.com/go-go-golems/go-emrichen/pkg/env"
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
 	"regexp"
 	"strings"
+	"sync"
 	"text/template"
+	"text/template/parse"
 )
 
 func (ei *Interpreter) handleFormat(node *yaml.Node) (*yaml.Node, error) {
@@ -22,58 +25,225 @@ func (ei *Interpreter) handleFormat(node *yaml.Node) (*yaml.Node, error) {
 }
 
 func (ei *Interpreter) renderFormatString(formatString string) (string, error) {
+	format, err := ei.parseFormatString(formatString)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := ei.formatData(format)
+	if err != nil {
+		return "", err
+	}
+
+	var formatted bytes.Buffer
+	if err := format.tmpl.Execute(&formatted, data); err != nil {
+		return "", errors.Wrap(err, "error executing format template")
+	}
+
+	return formatted.String(), nil
+}
+
+// formatData returns the variables passed to the template of a format
+// string, converted to plain Go values. Only the variables referred to by the
+// template are collected, unless it uses the whole data (for example with
+// `{{ toJson . }}`), in which case all the defaults are evaluated.
+func (ei *Interpreter) formatData(format *formatTemplate) (map[string]interface{}, error) {
+	frame := ei.env.GetCurrentFrame()
+	if frame == nil {
+		return map[string]interface{}{}, nil
+	}
+	if format.allVariables {
+		all := frame.Variables()
+		vars := make(map[string]interface{}, len(all))
+		for name, v := range all {
+			v, err := ei.resolveVariable(v)
+			if err != nil {
+				return nil, err
+			}
+			vars[name] = env.NodeToValue(v)
+		}
+		return vars, nil
+	}
+
+	vars := make(map[string]interface{}, len(format.variables))
+	for _, name := range format.variables {
+		v, ok, err := ei.env.ResolveVar(name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			vars[name] = env.NodeToValue(v)
+		}
+	}
+	return vars, nil
+}
+
+// formatCache caches the parsed templates of !Format nodes by format string.
+// It is safe for concurrent use, and shared by the interpreters rendering a
+// compiled Template.
+type formatCache struct {
+	templates sync.Map
+}
+
+// formatTemplate is a parsed format string.
+type formatTemplate struct {
+	tmpl *template.Template
+	// variables are the names of the variables the template refers to, if
+	// allVariables is false.
+	variables    []string
+	allVariables bool
+}
+
+// parseFormatString returns the Go template for a format string, bound to the
+// functions of the interpreter. Parsed templates are cached, and cloned for
+// every use so that the template functions can refer to the interpreter.
+func (ei *Interpreter) parseFormatString(formatString string) (*formatTemplate, error) {
+	if cached, ok := ei.formats.templates.Load(formatString); ok {
+		format := *cached.(*formatTemplate)
+		tmpl, err := format.tmpl.Clone()
+		if err != nil {
+			return nil, errors.Wrap(err, "error parsing format string")
+		}
+		format.tmpl = tmpl.Funcs(ei.formatFuncMap())
+		return &format, nil
+	}
+
 	// Transform the template to the Go template format.
-	formatString, err := transformTemplate(formatString)
+	transformed, err := transformTemplate(formatString)
 	if err != nil {
-		return "", errors.Wrap(err, "error transforming template")
+		return nil, errors.Wrap(err, "error transforming template")
 	}
 
 	tmpl := template.New("format")
 	for _, funcMap := range ei.funcmaps {
-		tmpl = tmpl.Funcs(funcMap)
+		tmpl = tmpl.Funcs(ei.sandboxFuncMap(funcMap))
+	}
+	tmpl = tmpl.Funcs(ei.formatFuncMap())
+	tmpl, err = tmpl.Parse(transformed)
+	if err != nil {
+		return nil, errors.Wrap(err, "error parsing format string")
 	}
 
-	tmpl = tmpl.Funcs(
-		map[string]interface{}{
-			"lookup": func(path string) interface{} {
-				v, err := ei.LookupFirst(path)
-				if err != nil {
-					return nil
-				}
-				v_, _ := NodeToInterface(v)
-				return v_
-			},
-			"lookupAll": func(path string) []interface{} {
-				v, err := ei.LookupAll(path)
-				if err != nil {
-					return nil
-				}
-				v_, _ := NodeToSlice(v)
-				return v_
-			},
-			"exists": func(path string) bool {
-				_, err := ei.LookupFirst(path)
-				return err == nil
-			},
-		},
-	)
-	tmpl, err = tmpl.Parse(formatString)
+	format := &formatTemplate{tmpl: tmpl}
+	format.variables, format.allVariables = templateVariables(tmpl)
+
+	// the cached template is only ever cloned, never executed
+	cached := *format
+	cached.tmpl, err = tmpl.Clone()
 	if err != nil {
-		return "", errors.Wrap(err, "error parsing format string")
+		return nil, errors.Wrap(err, "error parsing format string")
 	}
+	ei.formats.templates.Store(formatString, &cached)
+	return format, nil
+}
 
-	var formatted bytes.Buffer
-	frame := ei.env.GetCurrentFrame()
-	vars := map[string]interface{}{}
-	if frame.Variables != nil {
-		vars = frame.Variables
+// templateVariables returns the names of the top-level variables a template
+// refers to, as `.name` or `$.name`. If the template uses the data as a whole
+// (`.` or `$`), it returns true instead. Fields of other values, such as the
+// items of a `range`, are included as well, which is harmless.
+func templateVariables(tmpl *template.Template) ([]string, bool) {
+	names := map[string]bool{}
+	all := false
+
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		if all || node == nil {
+			return
+		}
+		switch n := node.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, child := range n.Nodes {
+				walk(child)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, cmd := range n.Cmds {
+				walk(cmd)
+			}
+		case *parse.CommandNode:
+			for _, arg := range n.Args {
+				walk(arg)
+			}
+		case *parse.ChainNode:
+			walk(n.Node)
+		case *parse.FieldNode:
+			names[n.Ident[0]] = true
+		case *parse.VariableNode:
+			if n.Ident[0] == "$" {
+				if len(n.Ident) == 1 {
+					all = true
+					return
+				}
+				names[n.Ident[1]] = true
+			}
+		case *parse.DotNode:
+			all = true
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		}
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			walk(t.Tree.Root)
+		}
+	}
+	if all {
+		return nil, true
 	}
 
-	if err := tmpl.Execute(&formatted, vars); err != nil {
-		return "", errors.Wrap(err, "error executing format template")
+	ret := make([]string, 0, len(names))
+	for name := range names {
+		ret = append(ret, name)
 	}
+	return ret, false
+}
 
-	return formatted.String(), nil
+// formatFuncMap returns the built-in template functions, which look up
+// variables of the interpreter.
+func (ei *Interpreter) formatFuncMap() template.FuncMap {
+	return template.FuncMap{
+		"lookup": func(path string) interface{} {
+			v, err := ei.LookupFirst(path)
+			if err != nil {
+				return nil
+			}
+			return env.NodeToValue(v)
+		},
+		"lookupAll": func(path string) []interface{} {
+			v, err := ei.LookupAll(path)
+			if err != nil {
+				return nil
+			}
+			v_, _ := env.NodeToValue(v).([]interface{})
+			return v_
+		},
+		"exists": func(path string) (bool, error) {
+			_, err := ei.LookupFirst(path)
+			if errors.Is(err, ErrPathNotFound) {
+				return false, nil
+			}
+			return err == nil, err
+		},
+	}
 }
 
 // transformTemplate converts templates from the old Emrichen format to Go template format.
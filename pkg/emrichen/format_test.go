@@ -79,3 +79,38 @@ Details: {{lookup "$.details[?(@.important==true)]"}}`,
 		})
 	}
 }
+
+func TestFormatTemplateData(t *testing.T) {
+	tests := []testCase{
+		{
+			name:      "Only referenced variables",
+			inputYAML: `!Format "{a} {{ $.b }} {{ if .c }}{{ .d.e }}{{ end }}"`,
+			initVars: map[string]interface{}{
+				"a": 1, "b": 2, "c": true, "d": map[string]interface{}{"e": 3},
+			},
+			expected: `1 2 3`,
+		},
+		{
+			name:      "Missing variable",
+			inputYAML: `!Format "{a} {missing}"`,
+			initVars:  map[string]interface{}{"a": 1},
+			expected:  `1 <no value>`,
+		},
+		{
+			name:      "Whole data",
+			inputYAML: `!Format "{{ range $k, $v := . }}{{ $k }}={{ $v }};{{ end }}"`,
+			initVars:  map[string]interface{}{"a": 1, "b": 2},
+			expected:  `a=1;b=2;`,
+		},
+		{
+			name:      "Range items",
+			inputYAML: `!Format "{{ range .items }}{{ .name }}{{ end }}"`,
+			initVars: map[string]interface{}{
+				"items": []interface{}{map[string]interface{}{"name": "x"}, map[string]interface{}{"name": "y"}},
+			},
+			expected: `xy`,
+		},
+	}
+
+	runTests(t, tests)
+}
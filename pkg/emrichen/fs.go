@@ -0,0 +1,117 @@
+package emrichen
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// WithFS makes the !Include* tags read files from fsys instead of the
+// operating system's filesystem, e.g. an embed.FS or an overlay created with
+// NewOverlayFS. Include paths and source files are then slash-separated paths
+// within fsys, with absolute paths referring to its root. A Sandbox doesn't
+// confine fsys: use an fs.FS that can't be escaped, such as os.Root.FS rather
+// than os.DirFS.
+func WithFS(fsys fs.FS) InterpreterOption {
+	return func(ei *Interpreter) error {
+		ei.fs = fsFileSystem{fsys: fsys}
+		return nil
+	}
+}
+
+// fileSystem is the filesystem used by the !Include* tags, with the path
+// semantics that go with it.
+type fileSystem interface {
+	Open(name string) (fs.File, error)
+	Stat(name string) (fs.FileInfo, error)
+	Glob(pattern string) ([]string, error)
+	Join(elem ...string) string
+	Dir(name string) string
+	IsAbs(name string) bool
+	// SameFile returns true if both paths refer to the same file.
+	SameFile(a string, b string) bool
+}
+
+// osFileSystem uses the operating system's filesystem and paths.
+type osFileSystem struct{}
+
+var _ fileSystem = osFileSystem{}
+
+func (osFileSystem) Open(name string) (fs.File, error) {
+	return os.Open(name)
+}
+
+func (osFileSystem) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (osFileSystem) Glob(pattern string) ([]string, error) {
+	return filepath.Glob(pattern)
+}
+
+func (osFileSystem) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (osFileSystem) Dir(name string) string {
+	return filepath.Dir(name)
+}
+
+func (osFileSystem) IsAbs(name string) bool {
+	return filepath.IsAbs(name)
+}
+
+func (osFileSystem) SameFile(a string, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return filepath.Clean(a) == filepath.Clean(b)
+	}
+	return absA == absB
+}
+
+// fsFileSystem uses an fs.FS, with slash-separated paths.
+type fsFileSystem struct {
+	fsys fs.FS
+}
+
+var _ fileSystem = fsFileSystem{}
+
+// fsPath converts a path to the unrooted form expected by fs.FS.
+func fsPath(name string) string {
+	name = strings.TrimPrefix(path.Clean(name), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+func (f fsFileSystem) Open(name string) (fs.File, error) {
+	return f.fsys.Open(fsPath(name))
+}
+
+func (f fsFileSystem) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(f.fsys, fsPath(name))
+}
+
+func (f fsFileSystem) Glob(pattern string) ([]string, error) {
+	return fs.Glob(f.fsys, fsPath(pattern))
+}
+
+func (fsFileSystem) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+func (fsFileSystem) Dir(name string) string {
+	return path.Dir(name)
+}
+
+func (fsFileSystem) IsAbs(name string) bool {
+	return path.IsAbs(name)
+}
+
+func (fsFileSystem) SameFile(a string, b string) bool {
+	return fsPath(a) == fsPath(b)
+}
@@ -0,0 +1,89 @@
+package emrichen
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIncludeFromFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app/main.yml": {Data: []byte(`labels: !Include ../common/labels.yml
+text: !IncludeText notes.txt
+base64: !IncludeBase64 notes.txt
+binary: !IncludeBinary notes.txt
+parts: !IncludeGlob parts/*.yml
+`)},
+		"app/notes.txt":     {Data: []byte("hello")},
+		"app/parts/a.yml":   {Data: []byte("part: a\n")},
+		"app/parts/b.yml":   {Data: []byte("part: b\n")},
+		"common/labels.yml": {Data: []byte("team: platform\n")},
+	}
+
+	ei, err := NewInterpreter(WithFS(fsys))
+	require.NoError(t, err)
+
+	output := processToYAML(t, ei, "!Include app/main.yml")
+	assert.Equal(t, `labels:
+    team: platform
+text: hello
+base64: aGVsbG8=
+binary: hello
+parts:
+    - part: a
+    - part: b
+`, output)
+
+	// absolute paths refer to the root of the filesystem
+	ei.SetSourceFile("app/main.yml")
+	output = processToYAML(t, ei, "!Include /common/labels.yml")
+	assert.Equal(t, "team: platform\n", output)
+}
+
+func TestIncludeFromFSErrors(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.yml": {Data: []byte("b: !Include b.yml\n")},
+		"b.yml": {Data: []byte("a: !Include /a.yml\n")},
+	}
+
+	ei, err := NewInterpreter(WithFS(fsys))
+	require.NoError(t, err)
+
+	_, err = ei.Process(parseNode(t, "!Include a.yml"))
+	var cycleErr *IncludeCycleError
+	require.True(t, errors.As(err, &cycleErr))
+	assert.Equal(t, []string{"a.yml", "b.yml", "/a.yml"}, cycleErr.Chain)
+
+	_, err = ei.Process(parseNode(t, "!Include missing.yml"))
+	assert.Error(t, err)
+}
+
+func TestOverlayFS(t *testing.T) {
+	local := fstest.MapFS{
+		"templates/service.yml": {Data: []byte("name: local\n")},
+		"templates/extra.yml":   {Data: []byte("name: extra\n")},
+	}
+	embedded := fstest.MapFS{
+		"templates/service.yml": {Data: []byte("name: embedded\n")},
+		"templates/base.yml":    {Data: []byte("name: base\n")},
+	}
+	fsys := NewOverlayFS(local, embedded)
+
+	require.NoError(t, fstest.TestFS(fsys,
+		"templates/service.yml", "templates/extra.yml", "templates/base.yml"))
+
+	ei, err := NewInterpreter(WithFS(fsys))
+	require.NoError(t, err)
+
+	output := processToYAML(t, ei, "!Include templates/service.yml")
+	assert.Equal(t, "name: local\n", output)
+
+	output = processToYAML(t, ei, "!Include templates/base.yml")
+	assert.Equal(t, "name: base\n", output)
+
+	output = processToYAML(t, ei, "!IncludeGlob templates/*.yml")
+	assert.Equal(t, "- name: base\n- name: extra\n- name: local\n", output)
+}
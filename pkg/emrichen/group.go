@@ -1,83 +1,95 @@
 package emrichen
 
-import (
-	"fmt"
-
-	"github.com/pkg/errors"
-	"gopkg.in/yaml.v3"
-)
+import "gopkg.in/yaml.v3"
+
+var groupArgs = []ParsedVariable{
+	{Name: "over", Required: true, Expand: true},
+	{Name: "by", Required: true},
+	{Name: "template"},
+	{Name: "as"},
+	{Name: "key_as"},
+	{Name: "aggregate"},
+	{Name: "group_as"},
+	{Name: "sort", Expand: true},
+}
 
 func (ei *Interpreter) handleGroup(node *yaml.Node) (*yaml.Node, error) {
 	if node.Kind != yaml.MappingNode {
-		return nil, errors.New("!Group requires a mapping node")
+		return nil, tagArgumentErrorf("!Group requires a mapping node")
 	}
 
-	args, err := ei.ParseArgs(node, []ParsedVariable{
-		{Name: "over", Required: true, Expand: true},
-		{Name: "by", Required: true},
-		{Name: "template"},
-		{Name: "as"},
-	})
+	args, err := ei.ParseArgs(node, groupArgs)
 	if err != nil {
 		return nil, err
 	}
 
 	overNode := args["over"]
-	if overNode.Kind != yaml.SequenceNode && overNode.Kind != yaml.MappingNode {
-		return nil, errors.New("!Group 'over' argument must be a sequence or mapping")
+
+	var keyNodes, itemNodes []*yaml.Node
+	switch overNode.Kind {
+	case yaml.SequenceNode:
+		itemNodes = overNode.Content
+	case yaml.MappingNode:
+		for i := 0; i < len(overNode.Content); i += 2 {
+			keyNodes = append(keyNodes, overNode.Content[i])
+			itemNodes = append(itemNodes, overNode.Content[i+1])
+		}
+	case yaml.DocumentNode, yaml.ScalarNode, yaml.AliasNode:
+		return nil, tagArgumentErrorf("!Group 'over' argument must be a sequence or mapping")
 	}
 
 	byNode, ok := args["by"]
 	if !ok {
-		return nil, errors.New("!Group requires a 'by' argument")
+		return nil, tagArgumentErrorf("!Group requires a 'by' argument")
 	}
 
 	templateNode := args["template"]
+	aggregateNode := args["aggregate"]
 
-	varName := "item"
-	asNode, ok := args["as"]
-	if ok {
-		if asNode.Kind != yaml.ScalarNode {
-			return nil, errors.New("!Group 'as' argument must be a scalar")
-		}
-		varName = asNode.Value
+	varName, err := varNameArgument(args, "as", "item")
+	if err != nil {
+		return nil, err
+	}
+	keyVarName, err := varNameArgument(args, "key_as", "")
+	if err != nil {
+		return nil, err
+	}
+	groupVarName, err := varNameArgument(args, "group_as", "group")
+	if err != nil {
+		return nil, err
 	}
 
-	groups := make(map[interface{}][]*yaml.Node)
-	var groupByMapping bool
-
-	if overNode.Kind == yaml.MappingNode {
-		groupByMapping = true
+	mode, err := parseSortMode("!Group", args["sort"])
+	if err != nil {
+		return nil, err
 	}
 
-	for i := 0; i < len(overNode.Content); i++ {
-		var itemNode *yaml.Node
+	groups := newOrderedMapping()
 
-		if groupByMapping {
-			if i%2 != 0 { // Skip value nodes
-				return nil, nil
-			}
-			itemNode = overNode.Content[i+1]
-		} else {
-			itemNode = overNode.Content[i]
+	for i, itemNode := range itemNodes {
+		vars := map[string]interface{}{
+			varName: itemNode,
 		}
-
-		item, ok := NodeToInterface(itemNode)
-		if !ok {
-			return nil, errors.Errorf("could not get item for node: %v", itemNode)
+		if keyVarName != "" && keyNodes != nil {
+			vars[keyVarName] = keyNodes[i]
 		}
 
-		err = ei.env.With(map[string]interface{}{
-			varName: item,
-		}, func() error {
+		err = ei.env.With(vars, func() error {
 			groupKeyNode, err := ei.Process(byNode)
 			if err != nil {
 				return err
 			}
-
-			groupKey, ok := NodeToInterface(groupKeyNode)
-			if !ok {
-				return errors.Errorf("could not get group key for node: %v", groupKeyNode)
+			// items whose key evaluates to !Void are not grouped
+			if groupKeyNode == nil {
+				return nil
+			}
+			if groupKeyNode.Kind != yaml.ScalarNode {
+				return tagArgumentErrorf("!Group 'by' argument must evaluate to a scalar")
+			}
+			keyNode := &yaml.Node{
+				Kind:  yaml.ScalarNode,
+				Tag:   groupKeyNode.Tag,
+				Value: groupKeyNode.Value,
 			}
 
 			var result *yaml.Node
@@ -86,11 +98,22 @@ func (ei *Interpreter) handleGroup(node *yaml.Node) (*yaml.Node, error) {
 				if err != nil {
 					return err
 				}
+				if result == nil {
+					return nil
+				}
 			} else {
 				result = itemNode
 			}
 
-			groups[groupKey] = append(groups[groupKey], result)
+			group, ok := groups.Get(keyNode)
+			if !ok {
+				group = &yaml.Node{
+					Kind: yaml.SequenceNode,
+					Tag:  "!!seq",
+				}
+				groups.Set(keyNode, group)
+			}
+			group.Content = append(group.Content, result)
 			return nil
 		})
 
@@ -99,24 +122,62 @@ func (ei *Interpreter) handleGroup(node *yaml.Node) (*yaml.Node, error) {
 		}
 	}
 
-	resultContent := make([]*yaml.Node, 0, len(groups)*2)
-	for k, v := range groups {
-		keyNode := &yaml.Node{
-			Kind:  yaml.ScalarNode,
-			Tag:   "!!str",
-			Value: fmt.Sprintf("%v", k),
+	if aggregateNode != nil {
+		groups, err = ei.aggregateGroups(groups, aggregateNode, keyVarName, groupVarName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	groups.Sort(mode)
+	return groups.Node(), nil
+}
+
+// aggregateGroups replaces the items of each group by the result of the
+// aggregate template, evaluated with the items bound to groupVarName and the
+// group key bound to keyVarName, if set. Groups whose aggregate evaluates to
+// !Void are dropped.
+func (ei *Interpreter) aggregateGroups(
+	groups *orderedMapping,
+	aggregateNode *yaml.Node,
+	keyVarName string,
+	groupVarName string,
+) (*orderedMapping, error) {
+	ret := newOrderedMapping()
+	for i, keyNode := range groups.keys {
+		vars := map[string]interface{}{
+			groupVarName: groups.values[i],
 		}
-		valueNode := &yaml.Node{
-			Kind:    yaml.SequenceNode,
-			Tag:     "!!seq",
-			Content: v,
+		if keyVarName != "" {
+			vars[keyVarName] = keyNode
 		}
-		resultContent = append(resultContent, keyNode, valueNode)
+
+		var result *yaml.Node
+		err := ei.env.With(vars, func() error {
+			var err error
+			result, err = ei.Process(aggregateNode)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if result == nil {
+			continue
+		}
+		ret.Set(keyNode, result)
 	}
+	return ret, nil
+}
 
-	return &yaml.Node{
-		Kind:    yaml.MappingNode,
-		Tag:     "!!map",
-		Content: resultContent,
-	}, nil
+// varNameArgument returns the variable name given by the scalar argument name,
+// or defaultName if it is not set.
+func varNameArgument(args map[string]*yaml.Node, name string, defaultName string) (string, error) {
+	node, ok := args[name]
+	if !ok {
+		return defaultName, nil
+	}
+	if node.Kind != yaml.ScalarNode {
+		return "", tagArgumentErrorf("!Group '%s' argument must be a scalar", name)
+	}
+	return node.Value, nil
 }
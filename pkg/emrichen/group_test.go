@@ -2,6 +2,9 @@ package emrichen
 
 import (
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGroupTag(t *testing.T) {
@@ -126,3 +129,84 @@ func TestGroupTag(t *testing.T) {
 	// runTests function should be implemented to execute each test case
 	runTests(t, tests)
 }
+
+func TestGroupTagExtended(t *testing.T) {
+	tests := []testCase{
+		{
+			name: "Grouping Over Mapping",
+			inputYAML: `!Group
+  over:
+    web: {tier: frontend}
+    api: {tier: backend}
+    worker: {tier: backend}
+  key_as: name
+  by: !Lookup item.tier
+  template: !Var name`,
+			expected: `{frontend: [web], backend: [api, worker]}`,
+		},
+		{
+			name: "Aggregate Count",
+			inputYAML: `!Group
+  over: [{kind: a}, {kind: b}, {kind: a}]
+  by: !Lookup item.kind
+  aggregate: !Format "{{ len .group }}"`,
+			expected: `{a: "2", b: "1"}`,
+		},
+		{
+			name: "Aggregate Reshape With Key",
+			inputYAML: `!Group
+  over: [{kind: a, v: 1}, {kind: b, v: 2}, {kind: a, v: 3}]
+  by: !Lookup item.kind
+  template: !Lookup item.v
+  key_as: kind
+  group_as: values
+  aggregate:
+    name: !Var kind
+    values: !Var values`,
+			expected: `{a: {name: a, values: [1, 3]}, b: {name: b, values: [2]}}`,
+		},
+		{
+			name: "Void Key Skips Item",
+			inputYAML: `!Group
+  over: [{kind: a}, {}]
+  by: !If
+    test: !Exists item.kind
+    then: !Lookup item.kind
+    else: !Void
+  template: 1`,
+			expected: `{a: [1]}`,
+		},
+		{
+			name: "Non-Scalar Key",
+			inputYAML: `!Group
+  over: [{kind: [a]}]
+  by: !Lookup item.kind`,
+			expectError:        true,
+			expectErrorMessage: "!Group 'by' argument must evaluate to a scalar",
+		},
+	}
+
+	runTests(t, tests)
+}
+
+func TestGroupKeepsKeyType(t *testing.T) {
+	ei, err := NewInterpreter()
+	require.NoError(t, err)
+
+	output := processToYAML(t, ei, `!Group
+  over: [{id: 1, v: a}, {id: "2", v: b}, {id: true, v: c}]
+  by: !Lookup item.id
+  template: !Lookup item.v`)
+	assert.Equal(t, "1:\n    - a\n\"2\":\n    - b\ntrue:\n    - c\n", output)
+}
+
+func TestGroupSeparatesKeysOfDifferentTypes(t *testing.T) {
+	ei, err := NewInterpreter()
+	require.NoError(t, err)
+
+	output := processToYAML(t, ei, `!Group
+  over: [{id: 1, v: a}, {id: "1", v: b}, {id: 1, v: c}, {id: true, v: d}, {id: "true", v: e}]
+  by: !Lookup item.id
+  template: !Lookup item.v`)
+	assert.Equal(t, "1:\n    - a\n    - c\n\"1\":\n    - b\ntrue:\n    - d\n\"true\":\n    - e\n", output)
+}
@@ -2,12 +2,14 @@ package emrichen
 
 import "gopkg.in/yaml.v3"
 
+var ifArgs = []ParsedVariable{
+	{Name: "test", Required: true},
+	{Name: "then"},
+	{Name: "else"},
+}
+
 func (ei *Interpreter) handleIf(node *yaml.Node) (*yaml.Node, error) {
-	args, err := ei.ParseArgs(node, []ParsedVariable{
-		{Name: "test", Required: true},
-		{Name: "then"},
-		{Name: "else"},
-	})
+	args, err := ei.ParseArgs(node, ifArgs)
 	if err != nil {
 		return nil, err
 	}
@@ -1,20 +1,19 @@
 package emrichen
 
 import (
+	"bytes"
 	"encoding/base64"
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
 	"io"
-	"os"
-	"path/filepath"
 )
 
 func (ei *Interpreter) handleInclude(node *yaml.Node) (*yaml.Node, error) {
 	if node.Kind != yaml.ScalarNode {
-		return nil, errors.New("!Include requires a scalar value (the file path)")
+		return nil, tagArgumentErrorf("!Include requires a scalar value (the file path)")
 	}
 
-	filePath := node.Value
+	filePath := ei.resolveIncludePath(node.Value)
 	decodedNodes, err := ei.loadYaml(filePath)
 	if err != nil {
 		return nil, err
@@ -32,29 +31,35 @@ func (ei *Interpreter) handleInclude(node *yaml.Node) (*yaml.Node, error) {
 	}, nil
 }
 
+// loadYaml processes the documents of an included file, given as a path
+// returned by resolveIncludePath.
 func (ei *Interpreter) loadYaml(filePath string) ([]*yaml.Node, error) {
-	f, err := os.Open(filePath)
-	if err != nil {
-		return nil, errors.Wrap(err, "error reading file for !Include")
-	}
-	defer func(f *os.File) {
-		_ = f.Close()
-	}(f)
-	decoder := yaml.NewDecoder(f)
-
 	decodedNodes := make([]*yaml.Node, 0)
-	for {
-		includedNode := &yaml.Node{}
-
-		err = decoder.Decode(ei.CreateRawDecoder(includedNode))
-		if err == io.EOF {
-			break
-		}
+	err := ei.withInclude(filePath, func() error {
+		content, err := ei.readIncludeFile("!Include", filePath)
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		decodedNodes = append(decodedNodes, includedNode)
+		previousSourceFile := ei.sourceFile
+		ei.sourceFile = filePath
+		defer func() {
+			ei.sourceFile = previousSourceFile
+		}()
+
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		for {
+			err := decoder.Decode(ei.CreateDocumentsDecoder(&decodedNodes))
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return decodedNodes, nil
@@ -62,13 +67,13 @@ func (ei *Interpreter) loadYaml(filePath string) ([]*yaml.Node, error) {
 
 func (ei *Interpreter) handleIncludeBase64(node *yaml.Node) (*yaml.Node, error) {
 	if node.Kind != yaml.ScalarNode {
-		return nil, errors.New("!IncludeBase64 requires a scalar value (the file path)")
+		return nil, tagArgumentErrorf("!IncludeBase64 requires a scalar value (the file path)")
 	}
 
-	filePath := node.Value
-	fileContent, err := os.ReadFile(filePath)
+	filePath := ei.resolveIncludePath(node.Value)
+	fileContent, err := ei.readIncludeFile("!IncludeBase64", filePath)
 	if err != nil {
-		return nil, errors.Wrap(err, "error reading file for !IncludeBase64")
+		return nil, err
 	}
 
 	encodedContent := base64.StdEncoding.EncodeToString(fileContent)
@@ -77,13 +82,13 @@ func (ei *Interpreter) handleIncludeBase64(node *yaml.Node) (*yaml.Node, error)
 
 func (ei *Interpreter) handleIncludeBinary(node *yaml.Node) (*yaml.Node, error) {
 	if node.Kind != yaml.ScalarNode {
-		return nil, errors.New("!IncludeBinary requires a scalar value (the file path)")
+		return nil, tagArgumentErrorf("!IncludeBinary requires a scalar value (the file path)")
 	}
 
-	filePath := node.Value
-	fileContent, err := os.ReadFile(filePath)
+	filePath := ei.resolveIncludePath(node.Value)
+	fileContent, err := ei.readIncludeFile("!IncludeBinary", filePath)
 	if err != nil {
-		return nil, errors.Wrap(err, "error reading file for !IncludeBinary")
+		return nil, err
 	}
 
 	// The binary data needs to be properly handled as per your use case
@@ -91,26 +96,27 @@ func (ei *Interpreter) handleIncludeBinary(node *yaml.Node) (*yaml.Node, error)
 }
 
 func (ei *Interpreter) handleIncludeGlob(node *yaml.Node) (*yaml.Node, error) {
-	if node.Kind != yaml.ScalarNode {
-		return nil, errors.New("!IncludeGlob requires a scalar value (the glob pattern)")
-	}
-
-	patterns := []string{node.Value}
-	if node.Kind == yaml.SequenceNode {
+	var patterns []string
+	switch node.Kind {
+	case yaml.ScalarNode:
+		patterns = []string{node.Value}
+	case yaml.SequenceNode:
 		patterns = make([]string, len(node.Content))
 		for i, n := range node.Content {
 			if n.Kind != yaml.ScalarNode {
-				return nil, errors.Errorf("invalid glob pattern: %v", n.Value)
+				return nil, tagArgumentErrorf("!IncludeGlob patterns must be scalars")
 			}
 			patterns[i] = n.Value
 		}
+	case yaml.DocumentNode, yaml.MappingNode, yaml.AliasNode:
+		return nil, tagArgumentErrorf("!IncludeGlob requires a glob pattern or a sequence of glob patterns")
 	}
 
 	var nodes []*yaml.Node
 	for _, pattern := range patterns {
-		matches, err := filepath.Glob(pattern)
+		matches, err := ei.globIncludePattern(pattern)
 		if err != nil {
-			return nil, errors.Wrap(err, "error in globbing pattern")
+			return nil, err
 		}
 		for _, match := range matches {
 			includedNodes, err := ei.loadYaml(match)
@@ -130,14 +136,61 @@ func (ei *Interpreter) handleIncludeGlob(node *yaml.Node) (*yaml.Node, error) {
 
 func (ei *Interpreter) handleIncludeText(node *yaml.Node) (*yaml.Node, error) {
 	if node.Kind != yaml.ScalarNode {
-		return nil, errors.New("!IncludeText requires a scalar value (the file path)")
+		return nil, tagArgumentErrorf("!IncludeText requires a scalar value (the file path)")
 	}
 
-	filePath := node.Value
-	fileContent, err := os.ReadFile(filePath)
+	filePath := ei.resolveIncludePath(node.Value)
+	fileContent, err := ei.readIncludeFile("!IncludeText", filePath)
 	if err != nil {
-		return nil, errors.Wrap(err, "error reading file for !IncludeText")
+		return nil, err
 	}
 
 	return makeString(string(fileContent)), nil
 }
+
+// includeSearchDirs returns the directories in which relative include paths
+// are looked up: the directory of the including file (the working directory
+// if it is not known), followed by the paths given with WithIncludePaths.
+func (ei *Interpreter) includeSearchDirs() []string {
+	dir := "."
+	if ei.sourceFile != "" {
+		dir = ei.fs.Dir(ei.sourceFile)
+	}
+	return append([]string{dir}, ei.includePaths...)
+}
+
+// resolveIncludePath returns the path of the file referenced by an !Include*
+// tag, using the first search directory in which it exists. If it can't be
+// found, the path relative to the including file is returned.
+func (ei *Interpreter) resolveIncludePath(filePath string) string {
+	if ei.fs.IsAbs(filePath) {
+		return filePath
+	}
+	dirs := ei.includeSearchDirs()
+	for _, dir := range dirs {
+		candidate := ei.fs.Join(dir, filePath)
+		if _, err := ei.fs.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return ei.fs.Join(dirs[0], filePath)
+}
+
+// globIncludePattern returns the files matching an !IncludeGlob pattern, using
+// the first search directory in which it matches any file.
+func (ei *Interpreter) globIncludePattern(pattern string) ([]string, error) {
+	if ei.fs.IsAbs(pattern) {
+		matches, err := ei.fs.Glob(pattern)
+		return matches, errors.Wrap(err, "error in globbing pattern")
+	}
+	for _, dir := range ei.includeSearchDirs() {
+		matches, err := ei.fs.Glob(ei.fs.Join(dir, pattern))
+		if err != nil {
+			return nil, errors.Wrap(err, "error in globbing pattern")
+		}
+		if len(matches) > 0 {
+			return matches, nil
+		}
+	}
+	return nil, nil
+}
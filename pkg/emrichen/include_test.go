@@ -4,7 +4,12 @@ import (
 	"encoding/base64"
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestHandleIncludeBase64InDepth(t *testing.T) {
@@ -180,6 +185,29 @@ func TestHandleIncludeGlob(t *testing.T) {
 			expectErrorMessage: "",
 			expectPanic:        false,
 		},
+		{
+			name: "Sequence of glob patterns",
+			inputYAML: `!IncludeGlob
+  - test-data/file_glob_2.yml
+  - test-data/file_glob_*.yml`,
+			expected: `
+- item: file2
+- item: file1
+- item: file2
+            `,
+		},
+		{
+			name:               "Sequence with non-scalar pattern",
+			inputYAML:          `!IncludeGlob [[test-data/file_glob_*.yml]]`,
+			expectError:        true,
+			expectErrorMessage: "!IncludeGlob patterns must be scalars",
+		},
+		{
+			name:               "Mapping argument",
+			inputYAML:          `!IncludeGlob {pattern: test-data/file_glob_*.yml}`,
+			expectError:        true,
+			expectErrorMessage: "!IncludeGlob requires a glob pattern or a sequence of glob patterns",
+		},
 		{
 			name:        "Glob pattern with malformed file",
 			inputYAML:   `!IncludeGlob test-data/glob_malformed*.yml`,
@@ -237,3 +265,77 @@ func TestHandleIncludeText(t *testing.T) {
 
 	runTests(t, tests)
 }
+
+// writeFiles writes files (relative path to content) below dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+}
+
+func TestIncludeRelativeToIncludingFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"app/main.yml":       "labels: !Include ../common/labels.yml\nglob: !IncludeGlob parts/*.yml\n",
+		"app/parts/a.yml":    "part: a\n",
+		"common/labels.yml":  "team: !IncludeText team.txt\n",
+		"common/team.txt":    "platform",
+		"shared/extra.yml":   "extra: true\n",
+		"app/with-extra.yml": "!Include extra.yml\n",
+	})
+
+	ei, err := NewInterpreter(WithIncludePaths(filepath.Join(dir, "shared")))
+	require.NoError(t, err)
+
+	ei.SetSourceFile(filepath.Join(dir, "app", "root.yml"))
+	output := processToYAML(t, ei, "!Include main.yml")
+	assert.Equal(t, "labels:\n    team: platform\nglob:\n    - part: a\n", output)
+
+	output = processToYAML(t, ei, "!Include with-extra.yml")
+	assert.Equal(t, "extra: true\n", output)
+}
+
+func TestIncludeCycle(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"a.yml": "b: !Include b.yml\n",
+		"b.yml": "c: !Include c.yml\n",
+		"c.yml": "a: !Include a.yml\n",
+	})
+
+	ei, err := NewInterpreter()
+	require.NoError(t, err)
+
+	ei.SetSourceFile(filepath.Join(dir, "a.yml"))
+	_, err = ei.Process(parseNode(t, "!Include b.yml"))
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrIncludeCycle))
+
+	var cycleErr *IncludeCycleError
+	require.True(t, errors.As(err, &cycleErr))
+	assert.Equal(t, []string{
+		filepath.Join(dir, "a.yml"),
+		filepath.Join(dir, "b.yml"),
+		filepath.Join(dir, "c.yml"),
+		filepath.Join(dir, "a.yml"),
+	}, cycleErr.Chain)
+
+	var emrichenErr *Error
+	require.True(t, errors.As(err, &emrichenErr))
+	assert.Equal(t, filepath.Join(dir, "c.yml"), emrichenErr.File)
+}
+
+func TestIncludeSameFileTwiceIsNotACycle(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"labels.yml": "team: platform\n",
+	})
+
+	ei, err := NewInterpreter(WithIncludePaths(dir))
+	require.NoError(t, err)
+
+	output := processToYAML(t, ei, "[!Include labels.yml, !Include labels.yml]")
+	assert.Equal(t, "- team: platform\n- team: platform\n", output)
+}
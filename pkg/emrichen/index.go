@@ -8,15 +8,18 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+var indexArgs = []ParsedVariable{
+	{Name: "over", Required: true, Expand: true},
+	{Name: "by", Required: true},
+	{Name: "template"},
+	{Name: "as"},
+	{Name: "duplicates"},
+	{Name: "result_as"},
+	{Name: "sort", Expand: true},
+}
+
 func (ei *Interpreter) handleIndex(node *yaml.Node) (*yaml.Node, error) {
-	args, err := ei.ParseArgs(node, []ParsedVariable{
-		{Name: "over", Required: true, Expand: true},
-		{Name: "by", Required: true},
-		{Name: "template"},
-		{Name: "as"},
-		{Name: "duplicates"},
-		{Name: "result_as"},
-	})
+	args, err := ei.ParseArgs(node, indexArgs)
 	if err != nil {
 		return nil, err
 	}
@@ -30,7 +33,7 @@ func (ei *Interpreter) handleIndex(node *yaml.Node) (*yaml.Node, error) {
 	}
 
 	if overNode.Kind != yaml.SequenceNode {
-		return nil, errors.New("!Index 'over' argument must be a sequence")
+		return nil, tagArgumentErrorf("!Index 'over' argument must be a sequence")
 	}
 
 	var asVarName string
@@ -46,16 +49,15 @@ func (ei *Interpreter) handleIndex(node *yaml.Node) (*yaml.Node, error) {
 		resultVarName = "" // Default variable name
 	}
 
-	indexedResults := make(map[string]*yaml.Node)
-	duplicateKeys := make(map[string]bool)
+	mode, err := parseSortMode("!Index", args["sort"])
+	if err != nil {
+		return nil, err
+	}
 
-	for _, itemNode := range overNode.Content {
-		v__, ok := NodeToInterface(itemNode)
-		if !ok {
-			return nil, errors.Errorf("could not get item for node: %v", itemNode)
-		}
+	indexedResults := newOrderedMapping()
 
-		err = ei.env.With(map[string]interface{}{asVarName: v__}, func() error {
+	for _, itemNode := range overNode.Content {
+		err = ei.env.With(map[string]interface{}{asVarName: itemNode}, func() error {
 			var resultNode *yaml.Node
 			if templateExists {
 				resultNode, err = ei.Process(templateNode)
@@ -69,11 +71,7 @@ func (ei *Interpreter) handleIndex(node *yaml.Node) (*yaml.Node, error) {
 			// Expand byNode
 			byEnv := map[string]interface{}{}
 			if resultVarName != "" {
-				v, ok := NodeToInterface(resultNode)
-				if !ok {
-					return errors.Errorf("could not get result for node: %v", resultNode)
-				}
-				byEnv[resultVarName] = v
+				byEnv[resultVarName] = resultNode
 			}
 			var processedByNode *yaml.Node
 			err := ei.env.With(byEnv, func() error {
@@ -87,10 +85,14 @@ func (ei *Interpreter) handleIndex(node *yaml.Node) (*yaml.Node, error) {
 				return err
 			}
 			if processedByNode.Kind != yaml.ScalarNode {
-				return errors.New("!Index 'by' expression must evaluate to a scalar")
+				return tagArgumentErrorf("!Index 'by' expression must evaluate to a scalar")
 			}
 			by := processedByNode.Value
-			_, isDuplicate := duplicateKeys[by]
+			keyNode, err := ValueToNode(by)
+			if err != nil {
+				return err
+			}
+			_, isDuplicate := indexedResults.Get(keyNode)
 			if isDuplicate {
 				switch duplicateAction {
 				case "error":
@@ -100,12 +102,10 @@ func (ei *Interpreter) handleIndex(node *yaml.Node) (*yaml.Node, error) {
 					return nil
 				case "ignore":
 				default:
-					return errors.Errorf("Unknown duplicate action: %v", duplicateAction)
+					return tagArgumentErrorf("Unknown duplicate action: %v", duplicateAction)
 				}
 			}
-			duplicateKeys[by] = true
-
-			indexedResults[by] = resultNode
+			indexedResults.Set(keyNode, resultNode)
 
 			return nil
 		})
@@ -114,19 +114,6 @@ func (ei *Interpreter) handleIndex(node *yaml.Node) (*yaml.Node, error) {
 		}
 	}
 
-	// Convert the map to a sequence of YAML nodes
-	content := make([]*yaml.Node, 0, len(indexedResults)*2)
-	for k, v := range indexedResults {
-		keyNode, err := ValueToNode(k)
-		if err != nil {
-			return nil, err
-		}
-		content = append(content, keyNode, v)
-	}
-
-	return &yaml.Node{
-		Kind:    yaml.MappingNode,
-		Tag:     "!!map",
-		Content: content,
-	}, nil
+	indexedResults.Sort(mode)
+	return indexedResults.Node(), nil
 }
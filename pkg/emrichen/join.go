@@ -3,21 +3,22 @@ package emrichen
 import (
 	"strings"
 
-	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
 )
 
+var joinArgs = []ParsedVariable{
+	// don't expand items here yet
+	{Name: "items", Required: true, Expand: true},
+	{Name: "separator", Expand: true},
+}
+
 func (ei *Interpreter) handleJoin(node *yaml.Node) (*yaml.Node, error) {
 	separator := " " // Default separator
 	itemsNode := node
 
 	switch node.Kind {
 	case yaml.MappingNode:
-		args, err := ei.ParseArgs(node, []ParsedVariable{
-			// don't expand items here yet
-			{Name: "items", Required: true, Expand: true},
-			{Name: "separator", Expand: true},
-		})
+		args, err := ei.ParseArgs(node, joinArgs)
 		if err != nil {
 			return nil, err
 		}
@@ -25,7 +26,7 @@ func (ei *Interpreter) handleJoin(node *yaml.Node) (*yaml.Node, error) {
 		var ok bool
 		itemsNode, ok = args["items"]
 		if !ok || itemsNode.Kind != yaml.SequenceNode {
-			return nil, errors.New("!Join requires a sequence node for 'items'")
+			return nil, tagArgumentErrorf("!Join requires a sequence node for 'items'")
 		}
 
 		if sepNode, ok := args["separator"]; ok && sepNode.Kind == yaml.ScalarNode {
@@ -45,13 +46,13 @@ func (ei *Interpreter) handleJoin(node *yaml.Node) (*yaml.Node, error) {
 	case yaml.DocumentNode, yaml.ScalarNode, yaml.AliasNode:
 		// Or handle other kinds if necessary, otherwise this case might not be needed
 		// if only MappingNode and SequenceNode are valid inputs for !Join
-		return nil, errors.Errorf("!Join expects a Mapping or Sequence node, got %v", node.Kind)
+		return nil, tagArgumentErrorf("!Join expects a Mapping or Sequence node, got %v", node.Kind)
 	}
 
 	var items []string
 	for _, itemNode := range itemsNode.Content {
 		if itemNode.Kind != yaml.ScalarNode {
-			return nil, errors.New("!Join items must be scalar values")
+			return nil, tagArgumentErrorf("!Join items must be scalar values")
 		}
 		if itemNode.Tag == "!!null" {
 			continue
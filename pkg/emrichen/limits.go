@@ -0,0 +1,185 @@
+package emrichen
+
+import (
+	"context"
+	"io"
+	"io/fs"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Limit names a resource limit of the interpreter, as reported by LimitError.
+type Limit string
+
+const (
+	LimitDepth          Limit = "max depth"
+	LimitNodes          Limit = "max nodes"
+	LimitLoopIterations Limit = "max loop iterations"
+	LimitIncludeDepth   Limit = "max include depth"
+	LimitIncludeBytes   Limit = "max include bytes"
+)
+
+// Limits bounds the resources used by a single call to Process or
+// ProcessContext. A value of 0 means unlimited.
+type Limits struct {
+	// MaxDepth is the maximum nesting depth of processed nodes, including
+	// the nodes of included files.
+	MaxDepth int
+	// MaxNodes is the maximum number of nodes processed.
+	MaxNodes int
+	// MaxLoopIterations is the maximum number of !Loop iterations, summed
+	// over all loops.
+	MaxLoopIterations int
+	// MaxIncludeDepth is the maximum nesting depth of !Include and
+	// !IncludeGlob.
+	MaxIncludeDepth int
+	// MaxIncludeBytes is the maximum number of bytes read by all !Include*
+	// tags.
+	MaxIncludeBytes int64
+}
+
+// DefaultLimits are the limits of an interpreter created without WithLimits.
+// They only guard against runaway recursion.
+var DefaultLimits = Limits{
+	MaxDepth:        10000,
+	MaxIncludeDepth: 100,
+}
+
+// WithLimits configures the resource limits of the interpreter, replacing
+// DefaultLimits.
+func WithLimits(limits Limits) InterpreterOption {
+	return func(ei *Interpreter) error {
+		ei.limits = limits
+		return nil
+	}
+}
+
+// renderState tracks the resources used by a single call to ProcessContext.
+type renderState struct {
+	ctx            context.Context
+	depth          int
+	nodes          int
+	loopIterations int
+	includeDepth   int
+	// includeChain is the chain of files being included, outermost first.
+	includeChain []string
+	includeBytes int64
+}
+
+// ProcessContext is like Process, but stops with the context's error when ctx
+// is cancelled or its deadline is exceeded. The limits configured with
+// WithLimits apply to each call.
+func (ei *Interpreter) ProcessContext(ctx context.Context, node *yaml.Node) (*yaml.Node, error) {
+	previousState := ei.state
+	ei.state = &renderState{ctx: ctx}
+	defer func() {
+		ei.state = previousState
+	}()
+	return ei.Process(node)
+}
+
+// ProcessDocumentsContext is like ProcessDocuments, but respects ctx and the
+// configured limits like ProcessContext.
+func (ei *Interpreter) ProcessDocumentsContext(ctx context.Context, node *yaml.Node) ([]*yaml.Node, error) {
+	previousState := ei.state
+	ei.state = &renderState{ctx: ctx}
+	defer func() {
+		ei.state = previousState
+	}()
+	return ei.ProcessDocuments(node)
+}
+
+// enterNode is called before processing a node. It checks for cancellation
+// and the depth and node limits, and returns a function to call when leaving
+// the node.
+func (ei *Interpreter) enterNode() (func(), error) {
+	if err := ei.state.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ei.state.nodes++
+	if ei.limits.MaxNodes > 0 && ei.state.nodes > ei.limits.MaxNodes {
+		return nil, &LimitError{Limit: LimitNodes, Max: int64(ei.limits.MaxNodes)}
+	}
+
+	ei.state.depth++
+	if ei.limits.MaxDepth > 0 && ei.state.depth > ei.limits.MaxDepth {
+		ei.state.depth--
+		return nil, &LimitError{Limit: LimitDepth, Max: int64(ei.limits.MaxDepth)}
+	}
+	return func() {
+		ei.state.depth--
+	}, nil
+}
+
+// countLoopIteration is called for every !Loop iteration.
+func (ei *Interpreter) countLoopIteration() error {
+	if err := ei.state.ctx.Err(); err != nil {
+		return err
+	}
+	ei.state.loopIterations++
+	if ei.limits.MaxLoopIterations > 0 && ei.state.loopIterations > ei.limits.MaxLoopIterations {
+		return &LimitError{Limit: LimitLoopIterations, Max: int64(ei.limits.MaxLoopIterations)}
+	}
+	return nil
+}
+
+// withInclude runs f while including filePath, checking for include cycles
+// and the include depth limit.
+func (ei *Interpreter) withInclude(filePath string, f func() error) error {
+	chain := ei.state.includeChain
+	if ei.sourceFile != "" && len(chain) == 0 {
+		// the top-level file is part of the chain as well
+		chain = []string{ei.sourceFile}
+	}
+	for _, included := range chain {
+		if ei.fs.SameFile(included, filePath) {
+			return &IncludeCycleError{Chain: append(append([]string{}, chain...), filePath)}
+		}
+	}
+
+	previousChain := ei.state.includeChain
+	ei.state.includeChain = append(chain, filePath)
+	ei.state.includeDepth++
+	defer func() {
+		ei.state.includeChain = previousChain
+		ei.state.includeDepth--
+	}()
+
+	if ei.limits.MaxIncludeDepth > 0 && ei.state.includeDepth > ei.limits.MaxIncludeDepth {
+		return &LimitError{Limit: LimitIncludeDepth, Max: int64(ei.limits.MaxIncludeDepth)}
+	}
+	return f()
+}
+
+// readIncludeFile reads a file for one of the !Include* tags, counting its
+// size towards the include bytes limit.
+func (ei *Interpreter) readIncludeFile(tag string, filePath string) ([]byte, error) {
+	f, err := ei.fs.Open(filePath)
+	if errors.Is(err, ErrSandboxViolation) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading file for %s", tag)
+	}
+	defer func(f fs.File) {
+		_ = f.Close()
+	}(f)
+
+	var r io.Reader = f
+	if ei.limits.MaxIncludeBytes > 0 {
+		// read one byte more than allowed to detect files exceeding the limit
+		r = io.LimitReader(f, ei.limits.MaxIncludeBytes-ei.state.includeBytes+1)
+	}
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading file for %s", tag)
+	}
+
+	ei.state.includeBytes += int64(len(content))
+	if ei.limits.MaxIncludeBytes > 0 && ei.state.includeBytes > ei.limits.MaxIncludeBytes {
+		return nil, &LimitError{Limit: LimitIncludeBytes, Max: ei.limits.MaxIncludeBytes}
+	}
+	return content, nil
+}
@@ -0,0 +1,158 @@
+package emrichen
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func parseNode(t *testing.T, input string) *yaml.Node {
+	node := &yaml.Node{}
+	err := yaml.Unmarshal([]byte(input), node)
+	require.NoError(t, err)
+	return node
+}
+
+// writeIncludeChain writes n files including each other in turn, and returns
+// the path of the first one.
+func writeIncludeChain(t *testing.T, dir string, n int) string {
+	for i := 0; i < n; i++ {
+		content := "end: true\n"
+		if i < n-1 {
+			content = fmt.Sprintf("next: !Include chain-%d.yml\n", i+1)
+		}
+		path := filepath.Join(dir, fmt.Sprintf("chain-%d.yml", i))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	return filepath.Join(dir, "chain-0.yml")
+}
+
+func TestLimits(t *testing.T) {
+	dir := t.TempDir()
+	includeChain := writeIncludeChain(t, dir, 10)
+	bigFile := filepath.Join(dir, "big.txt")
+	require.NoError(t, os.WriteFile(bigFile, []byte(strings.Repeat("x", 100)), 0o644))
+
+	tests := []struct {
+		name          string
+		limits        Limits
+		inputYAML     string
+		expectedLimit Limit
+		expectedLine  int
+	}{
+		{
+			name:          "Depth",
+			limits:        Limits{MaxDepth: 3},
+			inputYAML:     "a:\n  b:\n    c:\n      d: 1",
+			expectedLimit: LimitDepth,
+			expectedLine:  3,
+		},
+		{
+			name:          "Nodes",
+			limits:        Limits{MaxNodes: 4},
+			inputYAML:     "[1, 2, 3, 4, 5]",
+			expectedLimit: LimitNodes,
+			expectedLine:  1,
+		},
+		{
+			name:   "Loop Iterations",
+			limits: Limits{MaxLoopIterations: 5},
+			inputYAML: `!Loop
+  over: [1, 2, 3]
+  template: !Loop
+    over: [1, 2, 3]
+    template: !Var item`,
+			expectedLimit: LimitLoopIterations,
+			expectedLine:  3,
+		},
+		{
+			name:          "Include Depth",
+			limits:        Limits{MaxIncludeDepth: 5},
+			inputYAML:     "!Include " + includeChain,
+			expectedLimit: LimitIncludeDepth,
+			expectedLine:  1,
+		},
+		{
+			name:          "Include Bytes",
+			limits:        Limits{MaxIncludeBytes: 150},
+			inputYAML:     "[!IncludeText " + bigFile + ", !IncludeText " + bigFile + "]",
+			expectedLimit: LimitIncludeBytes,
+			expectedLine:  1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ei, err := NewInterpreter(WithLimits(tc.limits))
+			require.NoError(t, err)
+
+			_, err = ei.ProcessContext(context.Background(), parseNode(t, tc.inputYAML))
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, ErrLimitExceeded))
+
+			var limitErr *LimitError
+			require.True(t, errors.As(err, &limitErr))
+			assert.Equal(t, tc.expectedLimit, limitErr.Limit)
+
+			var emrichenErr *Error
+			require.True(t, errors.As(err, &emrichenErr))
+			assert.Equal(t, tc.expectedLine, emrichenErr.Line)
+		})
+	}
+}
+
+func TestLimitsAreResetBetweenCalls(t *testing.T) {
+	ei, err := NewInterpreter(WithLimits(Limits{MaxLoopIterations: 3}))
+	require.NoError(t, err)
+
+	node := parseNode(t, `!Loop
+  over: [1, 2, 3]
+  template: !Var item`)
+	for i := 0; i < 2; i++ {
+		_, err = ei.Process(node)
+		require.NoError(t, err)
+	}
+}
+
+func TestDefaultLimitsStopDeepIncludes(t *testing.T) {
+	includeChain := writeIncludeChain(t, t.TempDir(), DefaultLimits.MaxIncludeDepth+2)
+
+	ei, err := NewInterpreter()
+	require.NoError(t, err)
+
+	_, err = ei.Process(parseNode(t, "!Include "+includeChain))
+	assert.True(t, errors.Is(err, ErrLimitExceeded))
+}
+
+func TestProcessContextCancellation(t *testing.T) {
+	ei, err := NewInterpreter()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = ei.ProcessContext(ctx, parseNode(t, "a: 1"))
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	_, err = ei.ProcessDocumentsContext(ctx, parseNode(t, `!Loop
+  over: [1, 2, 3]
+  as_documents: true
+  template: !Var item`))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+
+	// the interpreter can be used again after a cancelled call
+	documents, err := ei.ProcessDocuments(parseNode(t, "a: 1"))
+	require.NoError(t, err)
+	assert.Len(t, documents, 1)
+}
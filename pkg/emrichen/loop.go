@@ -1,30 +1,35 @@
 package emrichen
 
-import (
-	"github.com/pkg/errors"
-	"gopkg.in/yaml.v3"
-)
+import "gopkg.in/yaml.v3"
+
+var loopArgs = []ParsedVariable{
+	{Name: "over", Required: true, Expand: true},
+	{Name: "template", Required: true},
+	{Name: "as"},
+	{Name: "index_as"},
+	{Name: "previous_as"},
+	{Name: "index_start", Expand: true},
+	{Name: "as_documents", Expand: true},
+	{Name: "loop_as"},
+}
 
 func (ei *Interpreter) handleLoop(node *yaml.Node) (*yaml.Node, error) {
 	if node.Kind != yaml.MappingNode {
-		return nil, errors.New("!Loop requires a mapping node")
-	}
-
-	args, err := ei.ParseArgs(node, []ParsedVariable{
-		{Name: "over", Required: true, Expand: true},
-		{Name: "template", Required: true},
-		{Name: "as"},
-		{Name: "index_as"},
-		{Name: "previous_as"},
-		{Name: "index_start", Expand: true},
-		{Name: "as_documents"},
-	})
+		return nil, tagArgumentErrorf("!Loop requires a mapping node")
+	}
+
+	args, err := ei.ParseArgs(node, loopArgs)
 	if err != nil {
 		return nil, err
 	}
 
-	if _, ok := args["as_documents"]; ok {
-		return nil, errors.New("!Loop 'as_documents' argument is not supported yet")
+	asDocuments := false
+	if asDocumentsNode, ok := args["as_documents"]; ok {
+		b, ok := NodeToBool(asDocumentsNode)
+		if !ok {
+			return nil, tagArgumentErrorf("!Loop 'as_documents' argument must be a boolean")
+		}
+		asDocuments = b
 	}
 
 	overNode := args["over"]
@@ -34,7 +39,7 @@ func (ei *Interpreter) handleLoop(node *yaml.Node) (*yaml.Node, error) {
 	asVarName := "item"
 	if asNode, ok := args["as"]; ok {
 		if asNode.Kind != yaml.ScalarNode {
-			return nil, errors.New("!Loop 'as' argument must be a scalar")
+			return nil, tagArgumentErrorf("!Loop 'as' argument must be a scalar")
 		}
 		asVarName = asNode.Value
 	}
@@ -42,7 +47,7 @@ func (ei *Interpreter) handleLoop(node *yaml.Node) (*yaml.Node, error) {
 	indexAsVarName := ""
 	if indexNode, ok := args["index_as"]; ok {
 		if indexNode.Kind != yaml.ScalarNode {
-			return nil, errors.New("!Loop 'index_as' argument must be a scalar")
+			return nil, tagArgumentErrorf("!Loop 'index_as' argument must be a scalar")
 		}
 		indexAsVarName = indexNode.Value
 	}
@@ -50,111 +55,141 @@ func (ei *Interpreter) handleLoop(node *yaml.Node) (*yaml.Node, error) {
 	previousAsVarName := ""
 	if previousNode, ok := args["previous_as"]; ok {
 		if previousNode.Kind != yaml.ScalarNode {
-			return nil, errors.New("!Loop 'previous_as' argument must be a scalar")
+			return nil, tagArgumentErrorf("!Loop 'previous_as' argument must be a scalar")
 		}
 		previousAsVarName = previousNode.Value
 	}
+	loopAsVarName := ""
+	if loopNode, ok := args["loop_as"]; ok {
+		if loopNode.Kind != yaml.ScalarNode {
+			return nil, tagArgumentErrorf("!Loop 'loop_as' argument must be a scalar")
+		}
+		loopAsVarName = loopNode.Value
+	}
+
 	indexStart := 0
 	if indexStartNode, ok := args["index_start"]; ok {
 		v, ok := NodeToInt(indexStartNode)
 		if !ok {
-			return nil, errors.Errorf("could not get value for node: %v", indexStartNode)
+			return nil, tagArgumentErrorf("!Loop 'index_start' argument must be an integer")
 		}
 		indexStart = v
 	}
 
+	var keyNodes, itemNodes []*yaml.Node
+	switch overNode.Kind {
+	case yaml.SequenceNode:
+		itemNodes = overNode.Content
+	case yaml.MappingNode:
+		for i := 0; i < len(overNode.Content); i += 2 {
+			keyNodes = append(keyNodes, overNode.Content[i])
+			itemNodes = append(itemNodes, overNode.Content[i+1])
+		}
+	case yaml.DocumentNode, yaml.ScalarNode, yaml.AliasNode:
+		return nil, tagArgumentErrorf("!Loop 'over' must be a sequence or mapping node")
+	}
+
 	var loopOutput []*yaml.Node
 
-	previousNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
-
-	// Handle sequence and mapping nodes separately
-	if overNode.Kind == yaml.SequenceNode {
-		for i, itemNode := range overNode.Content {
-			if i < indexStart {
-				continue
-			}
-			v, ok := NodeToInterface(itemNode)
-			if !ok {
-				return nil, errors.Errorf("could not get value for node: %v", itemNode)
-			}
-			templateEnv := map[string]interface{}{
-				asVarName: v,
-			}
-			if indexAsVarName != "" {
-				templateEnv[indexAsVarName] = i
-			}
-			if previousAsVarName != "" {
-				v, ok := NodeToInterface(previousNode)
-				if !ok {
-					return nil, errors.Errorf("could not get value for node: %v", previousNode)
-				}
-				templateEnv[previousAsVarName] = v
-			}
-			var resultNode *yaml.Node
-			err = ei.env.With(templateEnv, func() error {
-				resultNode, err = ei.Process(templateNode)
-				return err
-			})
-			if err != nil {
-				return nil, err
-			}
-			if resultNode == nil {
-				continue
-			}
-			loopOutput = append(loopOutput, resultNode)
-			previousNode = itemNode
+	previousNode := nullNode()
+
+	for i, itemNode := range itemNodes {
+		if err := ei.countLoopIteration(); err != nil {
+			return nil, err
 		}
-		return &yaml.Node{
-			Kind:    yaml.SequenceNode,
-			Tag:     "!!seq",
-			Content: loopOutput,
-		}, nil
-	} else if overNode.Kind == yaml.MappingNode {
-		for i := 0; i < len(overNode.Content); i += 2 {
-			if i/2 < indexStart {
-				continue
-			}
-			keyNode := overNode.Content[i]
-			itemNode := overNode.Content[i+1]
-
-			v, ok := NodeToInterface(itemNode)
-			if !ok {
-				return nil, errors.Errorf("could not get value for node: %v", itemNode)
-			}
-			templateEnv := map[string]interface{}{
-				asVarName: v,
-			}
-			if indexAsVarName != "" {
-				templateEnv[indexAsVarName] = keyNode.Value
-			}
-			if previousAsVarName != "" {
-				v, ok := NodeToInterface(previousNode)
-				if !ok {
-					return nil, errors.Errorf("could not get value for node: %v", previousNode)
-				}
-				templateEnv[previousAsVarName] = v
-			}
-			var resultNode *yaml.Node
-			err = ei.env.With(templateEnv, func() error {
-				resultNode, err = ei.Process(templateNode)
-				return err
-			})
-			if err != nil {
-				return nil, err
-			}
-			if resultNode == nil {
-				continue
-			}
-			loopOutput = append(loopOutput, keyNode, resultNode)
-			previousNode = itemNode
+
+		templateEnv := map[string]interface{}{
+			asVarName: itemNode,
+		}
+
+		// mappings report their key as index
+		key := makeInt(i + indexStart)
+		if keyNodes != nil {
+			key = keyNodes[i]
 		}
+		if indexAsVarName != "" {
+			templateEnv[indexAsVarName] = key
+		}
+		if previousAsVarName != "" {
+			templateEnv[previousAsVarName] = previousNode
+		}
+		if loopAsVarName != "" {
+			templateEnv[loopAsVarName] = loopMetadata(itemNodes, i, indexStart, key)
+		}
+
+		var resultNode *yaml.Node
+		err = ei.env.With(templateEnv, func() error {
+			resultNode, err = ei.Process(templateNode)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if resultNode == nil {
+			continue
+		}
+		if err := checkNotDocuments(resultNode); err != nil {
+			return nil, err
+		}
+		if keyNodes != nil && !asDocuments {
+			loopOutput = append(loopOutput, keyNodes[i])
+		}
+		loopOutput = append(loopOutput, resultNode)
+		previousNode = itemNode
+	}
+
+	if keyNodes != nil && !asDocuments {
 		return &yaml.Node{
 			Kind:    yaml.MappingNode,
 			Tag:     "!!map",
 			Content: loopOutput,
 		}, nil
+	}
+	return loopSequence(loopOutput, asDocuments), nil
+}
+
+// loopMetadata returns the value of the `loop_as` variable for the i-th
+// iteration over items. previous and next are the neighbouring items of
+// `over`, or null at its boundaries.
+func loopMetadata(items []*yaml.Node, i int, indexStart int, key *yaml.Node) *yaml.Node {
+	previous, next := nullNode(), nullNode()
+	if i > 0 {
+		previous = items[i-1]
+	}
+	if i < len(items)-1 {
+		next = items[i+1]
+	}
+
+	return &yaml.Node{
+		Kind: yaml.MappingNode,
+		Tag:  "!!map",
+		Content: []*yaml.Node{
+			makeString("index"), makeInt(i + indexStart),
+			makeString("index0"), makeInt(i),
+			makeString("first"), makeBool(i == 0),
+			makeString("last"), makeBool(i == len(items)-1),
+			makeString("length"), makeInt(len(items)),
+			makeString("key"), key,
+			makeString("previous"), previous,
+			makeString("next"), next,
+		},
+	}
+}
 
-	} else {
-		return nil, errors.New("!Loop 'over' must be a sequence or mapping node")
+func nullNode() *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}
+}
+
+// loopSequence returns the output of a loop as a sequence, or as a list of
+// documents if asDocuments is set.
+func loopSequence(content []*yaml.Node, asDocuments bool) *yaml.Node {
+	tag := "!!seq"
+	if asDocuments {
+		tag = documentsTag
+	}
+	return &yaml.Node{
+		Kind:    yaml.SequenceNode,
+		Tag:     tag,
+		Content: content,
 	}
 }
@@ -1,6 +1,15 @@
 package emrichen
 
-import "testing"
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
 
 func TestLoopTag(t *testing.T) {
 	tests := []testCase{
@@ -81,7 +90,7 @@ func TestLoopTag(t *testing.T) {
   index_as: num
   index_start: 1
   template: !Format "Position {{.num}}: {{.position}}"`,
-			expected: `["Position 1: second", "Position 2: third"]`,
+			expected: `["Position 1: first", "Position 2: second", "Position 3: third"]`,
 		},
 		{
 			name: "Nested Loops",
@@ -131,17 +140,17 @@ func TestLoopTag(t *testing.T) {
   index_as: num
   index_start: 1
   template: !Format "Item {{.num}}: {{.item}}"`,
-			expected: `["Item 1: second", "Item 2: third"]`,
+			expected: `["Item 1: first", "Item 2: second", "Item 3: third"]`,
 		},
 		{
-			name: "Loop with 'as_documents' Option",
-			inputYAML: `!Loop
+			name: "Loop with 'as_documents' Option Below Document Level",
+			inputYAML: `items: !Loop
   over: ["one", "two", "three"]
   as: item
   as_documents: true
   template: !Var item`,
 			expectError:        true,
-			expectErrorMessage: "!Loop 'as_documents' argument is not supported yet",
+			expectErrorMessage: "!Loop 'as_documents' can only be used at the document level",
 		},
 		{
 			name: "Loop with Conditional Logic Inside",
@@ -190,8 +199,148 @@ func TestLoopTag(t *testing.T) {
 			expectError:        true,
 			expectErrorMessage: "could not convert first argument to float",
 		},
+		{
+			name: "Loop with Error Handling - Invalid index_start",
+			inputYAML: `!Loop
+  over: [1, 2]
+  index_start: one
+  template: !Var item`,
+			expectError:        true,
+			expectErrorMessage: "!Loop 'index_start' argument must be an integer",
+		},
 	}
 
 	// runTests function should be implemented to execute each test case
 	runTests(t, tests)
 }
+
+func TestLoopMetadata(t *testing.T) {
+	tests := []testCase{
+		{
+			name: "Index And Boundaries",
+			inputYAML: `!Loop
+  over: [a, b, c]
+  loop_as: loop
+  template: !Format "{{.loop.index0}}/{{.loop.length}} first={{.loop.first}} last={{.loop.last}}"`,
+			expected: `["0/3 first=true last=false", "1/3 first=false last=false", "2/3 first=false last=true"]`,
+		},
+		{
+			name: "Index Start Offsets Index",
+			inputYAML: `!Loop
+  over: [a, b]
+  index_start: 1
+  loop_as: loop
+  template: [!Lookup loop.index, !Lookup loop.index0, !Lookup loop.key]`,
+			expected: `[[1, 0, 1], [2, 1, 2]]`,
+		},
+		{
+			name: "Previous And Next",
+			inputYAML: `!Loop
+  over: [1, 2, 3]
+  loop_as: loop
+  template: [!Lookup loop.previous, !Lookup loop.next]`,
+			expected: `[[null, 2], [1, 3], [2, null]]`,
+		},
+		{
+			name: "Separators",
+			inputYAML: `!Join
+  separator: ""
+  items: !Loop
+    over: [x, y, z]
+    loop_as: loop
+    template: !If
+      test: !Lookup loop.last
+      then: !Var item
+      else: !Format "{{.item}},"`,
+			expected: `"x,y,z"`,
+		},
+		{
+			name: "Mapping Iteration",
+			inputYAML: `!Loop
+  over: {a: 1, b: 2}
+  loop_as: loop
+  template: {key: !Lookup loop.key, index: !Lookup loop.index, last: !Lookup loop.last, next: !Lookup loop.next}`,
+			expected: `{a: {key: a, index: 0, last: false, next: 2}, b: {key: b, index: 1, last: true, next: null}}`,
+		},
+		{
+			name: "Invalid loop_as",
+			inputYAML: `!Loop
+  over: [1]
+  loop_as: [loop]
+  template: !Var item`,
+			expectError:        true,
+			expectErrorMessage: "!Loop 'loop_as' argument must be a scalar",
+		},
+	}
+
+	runTests(t, tests)
+}
+
+func TestLoopAsDocuments(t *testing.T) {
+	ei, err := NewInterpreter()
+	require.NoError(t, err)
+
+	input := `!Defaults
+tenants: [acme, globex]
+---
+!Loop
+  over: !Var tenants
+  as: tenant
+  as_documents: true
+  template:
+    kind: Namespace
+    metadata: {name: !Var tenant}
+---
+kind: ConfigMap
+`
+	decoder := yaml.NewDecoder(strings.NewReader(input))
+	var documents []*yaml.Node
+	for {
+		err = decoder.Decode(ei.CreateDocumentsDecoder(&documents))
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+	}
+
+	require.Len(t, documents, 3)
+	assert.Equal(t, "kind: Namespace\nmetadata:\n    name: acme\n", marshalNode(t, documents[0]))
+	assert.Equal(t, "kind: Namespace\nmetadata:\n    name: globex\n", marshalNode(t, documents[1]))
+	assert.Equal(t, "kind: ConfigMap\n", marshalNode(t, documents[2]))
+}
+
+func TestLoopAsDocumentsOverMapping(t *testing.T) {
+	ei, err := NewInterpreter()
+	require.NoError(t, err)
+
+	node := &yaml.Node{}
+	err = yaml.Unmarshal([]byte(`!Loop
+  over: {a: 1, b: 2}
+  as_documents: true
+  template: {value: !Var item}`), node)
+	require.NoError(t, err)
+
+	documents, err := ei.ProcessDocuments(node)
+	require.NoError(t, err)
+	require.Len(t, documents, 2)
+	assert.Equal(t, "value: 1\n", marshalNode(t, documents[0]))
+	assert.Equal(t, "value: 2\n", marshalNode(t, documents[1]))
+}
+
+func TestLoopAsDocumentsWithSingleDocumentDecoder(t *testing.T) {
+	ei, err := NewInterpreter()
+	require.NoError(t, err)
+
+	var document interface{}
+	err = yaml.Unmarshal([]byte(`!Loop
+  over: [1, 2]
+  as_documents: true
+  template: !Var item`), ei.CreateDecoder(&document))
+	assert.True(t, errors.Is(err, ErrMultipleDocuments))
+}
+
+func marshalNode(t *testing.T, node *yaml.Node) string {
+	b, err := yaml.Marshal(node)
+	require.NoError(t, err)
+	return string(b)
+}
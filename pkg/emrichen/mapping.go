@@ -0,0 +1,66 @@
+package emrichen
+
+import (
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// processMapping processes the keys and values of an untagged mapping node.
+//
+// Keys carrying a tag are evaluated and must result in a scalar. A pair is
+// dropped if its key or its value evaluates to !Void. Two keys resulting in
+// the same value and type are reported as an error pointing at both definitions.
+func (ei *Interpreter) processMapping(node *yaml.Node) (*yaml.Node, error) {
+	content, err := ei.expandMergeKeys(node)
+	if err != nil {
+		return nil, err
+	}
+
+	retContent := make([]*yaml.Node, 0, len(content))
+	definedKeys := make(map[string]*yaml.Node, len(content)/2)
+	for i := 0; i < len(content); i += 2 {
+		key := content[i]
+		value := content[i+1]
+
+		k := key
+		if isCustomTag(key.Tag) {
+			k, err = ei.Process(key)
+			if err != nil {
+				return nil, err
+			}
+			if k == nil {
+				continue
+			}
+			if k.Kind != yaml.ScalarNode {
+				return nil, ei.wrapError(
+					errors.Errorf("mapping key %s must evaluate to a scalar", key.Tag), "", key)
+			}
+		}
+
+		if previous, ok := definedKeys[mappingKey(k)]; ok {
+			return nil, ei.wrapError(
+				errors.Errorf("duplicate mapping key %q, previously defined at %s",
+					k.Value, ei.nodePosition(previous)),
+				"", key)
+		}
+		definedKeys[mappingKey(k)] = key
+
+		v, err := ei.Process(value)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			continue
+		}
+		if err := checkNotDocuments(v); err != nil {
+			return nil, ei.wrapError(err, "", value)
+		}
+		retContent = append(retContent, k, v)
+	}
+
+	return &yaml.Node{
+		Kind:    yaml.MappingNode,
+		Content: retContent,
+		Tag:     "!!map",
+	}, nil
+}
@@ -0,0 +1,102 @@
+package emrichen
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestEmrichenDynamicKeys(t *testing.T) {
+	tests := []testCase{
+		{
+			name: "Format key",
+			inputYAML: `
+? !Format "{name}-config"
+: value`,
+			initVars: map[string]interface{}{"name": "app"},
+			expected: `app-config: value`,
+		},
+		{
+			name: "Var key",
+			inputYAML: `
+!Var key: value
+other: 1`,
+			initVars: map[string]interface{}{"key": "dynamic"},
+			expected: `
+dynamic: value
+other: 1`,
+		},
+		{
+			name: "Void key drops the pair",
+			inputYAML: `
+!Void foo: value
+other: 1`,
+			expected: `other: 1`,
+		},
+		{
+			name: "Conditional key",
+			inputYAML: `
+? !If
+  test: !Var enabled
+  then: feature
+  else: !Void
+: on
+other: 1`,
+			initVars: map[string]interface{}{"enabled": false},
+			expected: `other: 1`,
+		},
+		{
+			name: "Keys in loop template",
+			inputYAML: `
+!Loop
+  over: [a, b]
+  template:
+    !Format "{item}-key": !Var item`,
+			expected: `[{a-key: a}, {b-key: b}]`,
+		},
+		{
+			name: "Key evaluating to a sequence",
+			inputYAML: `
+!Var list: value`,
+			initVars:    map[string]interface{}{"list": []interface{}{1, 2}},
+			expectError: true,
+		},
+		{
+			name:      "Keys of different types",
+			inputYAML: `{1: int, "1": string, !Var key: bool}`,
+			initVars:  map[string]interface{}{"key": "true"},
+			expected:  `{1: int, "1": string, "true": bool}`,
+		},
+		{
+			name: "Duplicate dynamic key",
+			inputYAML: `
+foo: 1
+!Var key: 2`,
+			initVars:    map[string]interface{}{"key": "foo"},
+			expectError: true,
+		},
+	}
+
+	runTests(t, tests)
+}
+
+func TestDuplicateDynamicKeyPosition(t *testing.T) {
+	ei, err := NewInterpreter(WithVars(map[string]interface{}{"key": "foo"}))
+	require.NoError(t, err)
+
+	var result interface{}
+	err = yaml.Unmarshal([]byte(`
+foo: 1
+bar: 2
+!Var key: 3`), ei.CreateDecoder(&result))
+	require.Error(t, err)
+
+	var emrichenErr *Error
+	require.True(t, errors.As(err, &emrichenErr))
+	assert.Equal(t, 4, emrichenErr.Line)
+	assert.Equal(t, 1, emrichenErr.Column)
+	assert.Equal(t, `4:1: duplicate mapping key "foo", previously defined at 2:1`, err.Error())
+}
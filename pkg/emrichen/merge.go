@@ -1,17 +1,81 @@
 package emrichen
 
 import (
+	"fmt"
+
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
 )
 
+// deleteTag marks a value that removes the corresponding key when merging with !Merge.
+const deleteTag = "!Delete"
+
+type listMergeMode int
+
+const (
+	listReplace listMergeMode = iota
+	listAppend
+	listPrepend
+	listMergeByKey
+)
+
+// mergeOptions configures how !Merge combines its layers.
+type mergeOptions struct {
+	// deep merges nested mappings recursively instead of replacing them.
+	deep bool
+	// lists determines how sequences present in several layers are combined.
+	lists listMergeMode
+	// mergeKey identifies the items of sequences merged with listMergeByKey.
+	mergeKey string
+	// deleteNulls removes keys whose value is null in a later layer.
+	deleteNulls bool
+}
+
+var mergeArgs = []ParsedVariable{
+	{Name: "items", Required: true, Expand: true},
+	{Name: "sort", Expand: true},
+	{Name: "strategy", Expand: true},
+	{Name: "lists", Expand: true},
+	{Name: "merge_key", Expand: true},
+	{Name: "delete_nulls", Expand: true},
+}
+
 func (ei *Interpreter) handleMerge(node *yaml.Node) (*yaml.Node, error) {
-	if node.Kind != yaml.SequenceNode {
-		return nil, errors.New("!Merge requires a sequence of mapping nodes")
+	// !Delete markers are only allowed in the items
+	ei.mergeDepth++
+	defer func() {
+		ei.mergeDepth--
+	}()
+
+	itemsNode := node
+	mode := sortNone
+	options := mergeOptions{mergeKey: "name"}
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		args, err := ei.ParseArgs(node, mergeArgs)
+		if err != nil {
+			return nil, err
+		}
+		itemsNode = args["items"]
+		if itemsNode.Kind != yaml.SequenceNode {
+			return nil, tagArgumentErrorf("!Merge 'items' argument must be a sequence of mapping nodes")
+		}
+		mode, err = parseSortMode("!Merge", args["sort"])
+		if err != nil {
+			return nil, err
+		}
+		options, err = parseMergeOptions(args)
+		if err != nil {
+			return nil, err
+		}
+	case yaml.SequenceNode:
+	case yaml.DocumentNode, yaml.ScalarNode, yaml.AliasNode:
+		return nil, tagArgumentErrorf("!Merge requires a sequence of mapping nodes")
 	}
 
-	mergedMap := make(map[string]*yaml.Node)
-	for _, item := range node.Content {
+	merged := newOrderedMapping()
+	for _, item := range itemsNode.Content {
 		var err error
 		item, err = ei.Process(item)
 		if err != nil {
@@ -22,33 +86,254 @@ func (ei *Interpreter) handleMerge(node *yaml.Node) (*yaml.Node, error) {
 			continue
 		}
 		if item.Kind != yaml.MappingNode {
-			return nil, errors.New("!Merge items must be mapping nodes")
+			return nil, tagArgumentErrorf("!Merge items must be mapping nodes")
+		}
+
+		err = options.mergeMapping("", merged, item)
+		if err != nil {
+			return nil, err
 		}
+	}
+
+	merged.Sort(mode)
+	return merged.Node(), nil
+}
+
+func parseMergeOptions(args map[string]*yaml.Node) (mergeOptions, error) {
+	options := mergeOptions{mergeKey: "name"}
 
-		tempMap := make(map[string]*yaml.Node)
-		for i := 0; i < len(item.Content); i += 2 {
-			keyNode := item.Content[i]
-			valueNode := item.Content[i+1]
+	if strategyNode, ok := args["strategy"]; ok {
+		switch strategyNode.Value {
+		case "shallow":
+		case "deep":
+			options.deep = true
+		default:
+			return options, tagArgumentErrorf("!Merge 'strategy' argument must be shallow or deep")
+		}
+	}
 
-			tempMap[keyNode.Value] = valueNode
+	if listsNode, ok := args["lists"]; ok {
+		switch listsNode.Value {
+		case "replace":
+		case "append":
+			options.lists = listAppend
+		case "prepend":
+			options.lists = listPrepend
+		case "merge":
+			options.lists = listMergeByKey
+		default:
+			return options, tagArgumentErrorf("!Merge 'lists' argument must be replace, append, prepend or merge")
 		}
+	}
 
-		for k, v := range tempMap {
-			mergedMap[k] = v
+	if mergeKeyNode, ok := args["merge_key"]; ok {
+		if mergeKeyNode.Kind != yaml.ScalarNode {
+			return options, tagArgumentErrorf("!Merge 'merge_key' argument must be a scalar")
 		}
+		options.mergeKey = mergeKeyNode.Value
 	}
 
-	mergedContent := []*yaml.Node{}
-	for k, v := range mergedMap {
-		mergedContent = append(mergedContent, &yaml.Node{
-			Kind:  yaml.ScalarNode,
-			Value: k,
-		}, v)
+	if deleteNullsNode, ok := args["delete_nulls"]; ok {
+		b, ok := NodeToBool(deleteNullsNode)
+		if !ok {
+			return options, tagArgumentErrorf("!Merge 'delete_nulls' argument must be a boolean")
+		}
+		options.deleteNulls = b
+	}
+
+	return options, nil
+}
+
+// isDeletion returns true if value removes its key when merged.
+func (o mergeOptions) isDeletion(value *yaml.Node) bool {
+	if value.Tag == deleteTag {
+		return true
+	}
+	return o.deleteNulls && value.Kind == yaml.ScalarNode && value.Tag == "!!null"
+}
+
+// mergeMapping merges the entries of item into merged. path is the location
+// of merged in the merge result, used for error messages.
+func (o mergeOptions) mergeMapping(path string, merged *orderedMapping, item *yaml.Node) error {
+	for i := 0; i < len(item.Content); i += 2 {
+		key, value := item.Content[i], item.Content[i+1]
+		if o.isDeletion(value) {
+			merged.Delete(key)
+			continue
+		}
+
+		existing, ok := merged.Get(key)
+		if !ok {
+			merged.Set(key, o.stripDeletions(value))
+			continue
+		}
+
+		v, err := o.mergeValues(joinMergePath(path, key.Value), existing, value)
+		if err != nil {
+			return err
+		}
+		merged.Set(key, v)
+	}
+	return nil
+}
+
+// mergeValues merges override on top of base.
+func (o mergeOptions) mergeValues(path string, base *yaml.Node, override *yaml.Node) (*yaml.Node, error) {
+	if isNullNode(base) || isNullNode(override) {
+		return o.stripDeletions(override), nil
+	}
+
+	switch {
+	case base.Kind == yaml.MappingNode && override.Kind == yaml.MappingNode:
+		if !o.deep {
+			return o.stripDeletions(override), nil
+		}
+		merged := newOrderedMapping()
+		for i := 0; i < len(base.Content); i += 2 {
+			merged.Set(base.Content[i], base.Content[i+1])
+		}
+		if err := o.mergeMapping(path, merged, override); err != nil {
+			return nil, err
+		}
+		ret := merged.Node()
+		ret.Tag = base.Tag
+		return ret, nil
+
+	case base.Kind == yaml.SequenceNode && override.Kind == yaml.SequenceNode:
+		return o.mergeSequences(path, base, override)
+
+	case o.deep && base.Kind != override.Kind:
+		return nil, errors.Errorf("!Merge cannot merge %s into %s at %s",
+			nodeKindName(override), nodeKindName(base), path)
+
+	default:
+		return o.stripDeletions(override), nil
+	}
+}
+
+func (o mergeOptions) mergeSequences(path string, base *yaml.Node, override *yaml.Node) (*yaml.Node, error) {
+	override = o.stripDeletions(override)
+
+	var content []*yaml.Node
+	switch o.lists {
+	case listReplace:
+		return override, nil
+	case listAppend:
+		content = append(append(content, base.Content...), override.Content...)
+	case listPrepend:
+		content = append(append(content, override.Content...), base.Content...)
+	case listMergeByKey:
+		content = append(content, base.Content...)
+		for _, item := range override.Content {
+			key, ok := o.itemMergeKey(item)
+			if !ok {
+				content = append(content, item)
+				continue
+			}
+
+			idx := -1
+			for i, baseItem := range content {
+				if baseKey, ok := o.itemMergeKey(baseItem); ok && baseKey == key {
+					idx = i
+					break
+				}
+			}
+			if idx == -1 {
+				content = append(content, item)
+				continue
+			}
+
+			itemPath := fmt.Sprintf("%s[%s=%s]", path, o.mergeKey, key)
+			merged, err := o.mergeValues(itemPath, content[idx], item)
+			if err != nil {
+				return nil, err
+			}
+			content[idx] = merged
+		}
 	}
 
 	return &yaml.Node{
-		Kind:    yaml.MappingNode,
-		Content: mergedContent,
+		Kind:    yaml.SequenceNode,
+		Tag:     "!!seq",
+		Content: content,
 	}, nil
+}
 
+// itemMergeKey returns the value of the merge key of a sequence item, if
+// the item is a mapping containing it.
+func (o mergeOptions) itemMergeKey(item *yaml.Node) (string, bool) {
+	if item.Kind != yaml.MappingNode {
+		return "", false
+	}
+	for i := 0; i < len(item.Content); i += 2 {
+		if item.Content[i].Value == o.mergeKey && item.Content[i+1].Kind == yaml.ScalarNode {
+			return item.Content[i+1].Value, true
+		}
+	}
+	return "", false
+}
+
+// stripDeletions removes deletion markers from a value that is not merged
+// with anything, since there is nothing for them to delete.
+func (o mergeOptions) stripDeletions(node *yaml.Node) *yaml.Node {
+	if node.Kind != yaml.MappingNode && node.Kind != yaml.SequenceNode {
+		return node
+	}
+
+	ret := *node
+	ret.Content = make([]*yaml.Node, 0, len(node.Content))
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i < len(node.Content); i += 2 {
+			if o.isDeletion(node.Content[i+1]) {
+				continue
+			}
+			ret.Content = append(ret.Content, node.Content[i], o.stripDeletions(node.Content[i+1]))
+		}
+	} else {
+		for _, item := range node.Content {
+			if item.Tag == deleteTag {
+				continue
+			}
+			ret.Content = append(ret.Content, o.stripDeletions(item))
+		}
+	}
+	return &ret
+}
+
+func joinMergePath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func isNullNode(node *yaml.Node) bool {
+	return node.Kind == yaml.ScalarNode && node.Tag == "!!null"
+}
+
+func nodeKindName(node *yaml.Node) string {
+	//exhaustive:ignore
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "mapping"
+	case yaml.SequenceNode:
+		return "sequence"
+	case yaml.ScalarNode:
+		return "scalar"
+	default:
+		return "node"
+	}
+}
+
+// handleDelete returns a marker that removes the key it is assigned to when
+// merged on top of a mapping with !Merge. Using it outside of a !Merge item is
+// an error, since the marker would end up in the output.
+func (ei *Interpreter) handleDelete(node *yaml.Node) (*yaml.Node, error) {
+	if ei.mergeDepth == 0 {
+		return nil, tagArgumentErrorf("!Delete can only be used in !Merge items")
+	}
+	return &yaml.Node{
+		Kind: yaml.ScalarNode,
+		Tag:  deleteTag,
+	}, nil
 }
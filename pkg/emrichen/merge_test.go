@@ -2,6 +2,9 @@ package emrichen
 
 import (
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestEmrichenMergeTag(t *testing.T) {
@@ -82,3 +85,169 @@ func TestEmrichenMergeTag(t *testing.T) {
 
 	runTests(t, tests)
 }
+
+func TestEmrichenMergeStrategies(t *testing.T) {
+	tests := []testCase{
+		{
+			name: "Shallow Strategy Replaces Nested Mappings",
+			inputYAML: `!Merge
+  items:
+    - a: {x: 1, y: 2}
+    - a: {y: 3}
+  strategy: shallow`,
+			expected: "{a: {y: 3}}",
+		},
+		{
+			name: "Deep Strategy Merges Nested Mappings",
+			inputYAML: `!Merge
+  items:
+    - a: {x: 1, y: {p: 1, q: 2}}
+    - a: {y: {q: 3}, z: 4}
+  strategy: deep`,
+			expected: "{a: {x: 1, y: {p: 1, q: 3}, z: 4}}",
+		},
+		{
+			name: "Deep Strategy With Variables",
+			inputYAML: `!Merge
+  items:
+    - !Var base
+    - !Var override
+  strategy: deep`,
+			initVars: map[string]interface{}{
+				"base":     map[string]interface{}{"a": 1, "b": map[string]interface{}{"x": 10}},
+				"override": map[string]interface{}{"b": map[string]interface{}{"y": 20}, "c": 3},
+			},
+			expected: "{a: 1, b: {x: 10, y: 20}, c: 3}",
+		},
+		{
+			name: "Append Lists",
+			inputYAML: `!Merge
+  items:
+    - a: [1, 2]
+    - a: [3]
+  strategy: deep
+  lists: append`,
+			expected: "{a: [1, 2, 3]}",
+		},
+		{
+			name: "Prepend Lists",
+			inputYAML: `!Merge
+  items:
+    - a: [1, 2]
+    - a: [3]
+  lists: prepend`,
+			expected: "{a: [3, 1, 2]}",
+		},
+		{
+			name: "Merge Lists By Key",
+			inputYAML: `!Merge
+  items:
+    - containers:
+        - {name: app, image: app:v1, ports: [80]}
+        - {name: sidecar, image: proxy:v1}
+    - containers:
+        - {name: app, image: app:v2}
+        - {name: logger, image: logger:v1}
+  strategy: deep
+  lists: merge`,
+			expected: `
+containers:
+  - {name: app, image: app:v2, ports: [80]}
+  - {name: sidecar, image: proxy:v1}
+  - {name: logger, image: logger:v1}`,
+		},
+		{
+			name: "Merge Lists By Custom Key",
+			inputYAML: `!Merge
+  items:
+    - env: [{key: A, value: 1}, {key: B, value: 2}]
+    - env: [{key: B, value: 3}]
+  strategy: deep
+  lists: merge
+  merge_key: key`,
+			expected: "{env: [{key: A, value: 1}, {key: B, value: 3}]}",
+		},
+		{
+			name: "Delete Marker Removes Inherited Key",
+			inputYAML: `!Merge
+  items:
+    - {a: 1, b: {x: 1, y: 2}}
+    - {a: !Delete , b: {x: !Delete }}
+  strategy: deep`,
+			expected: "{b: {y: 2}}",
+		},
+		{
+			name: "Delete Marker Without Inherited Key",
+			inputYAML: `!Merge
+  - {a: 1}
+  - {b: {c: !Delete , d: 2}}`,
+			expected: "{a: 1, b: {d: 2}}",
+		},
+		{
+			name: "Delete Marker Produced By Tag In Item",
+			inputYAML: `!Merge
+  - {a: 1, b: 2}
+  - {a: !If {test: true, then: !Delete , else: 3}}`,
+			expected: "{b: 2}",
+		},
+		{
+			name:               "Delete Marker Outside Merge",
+			inputYAML:          "{a: 1, b: !Delete }",
+			expectError:        true,
+			expectErrorMessage: "!Delete can only be used in !Merge items",
+		},
+		{
+			name: "Delete Marker In Default Used By Merge",
+			inputYAML: `!With
+  vars: {removed: !Delete }
+  template: !Merge [{a: 1}, {a: !Var removed}]`,
+			expectError:        true,
+			expectErrorMessage: "!Delete can only be used in !Merge items",
+		},
+		{
+			name: "Keys Of Different Types",
+			inputYAML: `!Merge
+  - {1: int, "1": string}
+  - {"1": override, 1: !Delete }`,
+			expected: `{"1": override}`,
+		},
+		{
+			name: "Delete Nulls",
+			inputYAML: `!Merge
+  items:
+    - {a: 1, b: 2}
+    - {a: null}
+  delete_nulls: true`,
+			expected: "{b: 2}",
+		},
+		{
+			name: "Type Mismatch In Deep Strategy",
+			inputYAML: `!Merge
+  items:
+    - spec: {containers: [{name: app}]}
+    - spec: {containers: {name: app}}
+  strategy: deep`,
+			expectError:        true,
+			expectErrorMessage: "!Merge cannot merge mapping into sequence at spec.containers",
+		},
+		{
+			name: "Invalid Strategy",
+			inputYAML: `!Merge
+  items: [{a: 1}]
+  strategy: sideways`,
+			expectError: true,
+		},
+	}
+
+	runTests(t, tests)
+}
+
+func TestMergePreservesKeyNodes(t *testing.T) {
+	ei, err := NewInterpreter()
+	require.NoError(t, err)
+
+	output := processToYAML(t, ei, `!Merge
+  - {1: a, "2": b}
+  - {3: c}`)
+	assert.Equal(t, "1: a\n\"2\": b\n3: c\n", output)
+}
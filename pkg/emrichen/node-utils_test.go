@@ -1,6 +1,7 @@
 package emrichen
 
 import (
+	"github.com/go-go-golems/go-emrichen/pkg/env"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"gopkg.in/yaml.v3"
@@ -301,8 +302,13 @@ func TestNodeToInterface(t *testing.T) {
 					{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "false"},
 				},
 			},
-			expected: map[string]interface{}{"key1": 10, "key2": false},
-			ok:       true,
+			expected: func() *env.OrderedMap {
+				m := env.NewOrderedMap()
+				m.Set("key1", 10)
+				m.Set("key2", false)
+				return m
+			}(),
+			ok: true,
 		},
 		{
 			name: "Unsupported node kind",
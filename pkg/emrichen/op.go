@@ -5,23 +5,26 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/go-go-golems/go-emrichen/pkg/env"
 	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
 )
 
+var opArgs = []ParsedVariable{
+	{Name: "op", Required: true, Expand: true},
+	{Name: "a", Required: true, Expand: true},
+	{Name: "b", Required: true, Expand: true},
+}
+
 func (ei *Interpreter) handleOp(node *yaml.Node) (*yaml.Node, error) {
-	args, err := ei.ParseArgs(node, []ParsedVariable{
-		{Name: "op", Required: true, Expand: true},
-		{Name: "a", Required: true, Expand: true},
-		{Name: "b", Required: true, Expand: true},
-	})
+	args, err := ei.ParseArgs(node, opArgs)
 	if err != nil {
 		return nil, err
 	}
 
 	opNode := args["op"]
 	if opNode.Kind != yaml.ScalarNode {
-		return nil, errors.New("!Op 'op' argument must be a scalar")
+		return nil, tagArgumentErrorf("!Op 'op' argument must be a scalar")
 	}
 
 	aProcessed, bProcessed := args["a"], args["b"]
@@ -66,7 +69,7 @@ func (ei *Interpreter) handleOp(node *yaml.Node) (*yaml.Node, error) {
 		if !ok {
 			return nil, errors.Errorf("could not convert second argument to interface: %v", bProcessed)
 		}
-		return makeBool(reflect.DeepEqual(aVal, bVal)), nil
+		return makeBool(valuesEqual(aVal, bVal)), nil
 	case "≠", "!=", "!==", "ne":
 		if isNumberOperation {
 			return makeBool(a != b), nil
@@ -79,7 +82,7 @@ func (ei *Interpreter) handleOp(node *yaml.Node) (*yaml.Node, error) {
 		if !ok {
 			return nil, errors.Errorf("could not convert second argument to interface: %v", bProcessed)
 		}
-		return makeBool(!reflect.DeepEqual(aVal, bVal)), nil
+		return makeBool(!valuesEqual(aVal, bVal)), nil
 
 	// Less than, Greater than, Less than or equal to, Greater than or equal to
 	case "<", "lt":
@@ -167,7 +170,7 @@ func (ei *Interpreter) handleOp(node *yaml.Node) (*yaml.Node, error) {
 		return makeBool(!r), nil
 
 	default:
-		return nil, errors.Errorf("unsupported operator: %s", opNode.Value)
+		return nil, tagArgumentErrorf("unsupported operator: %s", opNode.Value)
 	}
 }
 
@@ -186,9 +189,15 @@ func (ei *Interpreter) opIn(bProcessed *yaml.Node, aProcessed *yaml.Node) (bool,
 		if !ok {
 			return false, errors.New("could not convert second argument to interface")
 		}
-		if reflect.DeepEqual(a, b) {
+		if valuesEqual(a, b) {
 			return true, nil
 		}
 	}
 	return false, nil
 }
+
+// valuesEqual compares values returned by NodeToInterface. Mappings are equal
+// regardless of the order of their keys.
+func valuesEqual(a interface{}, b interface{}) bool {
+	return reflect.DeepEqual(env.NodeToValue(a), env.NodeToValue(b))
+}
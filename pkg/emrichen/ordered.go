@@ -0,0 +1,184 @@
+package emrichen
+
+import (
+	"cmp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// orderedMapping accumulates the entries of a mapping node, keeping keys in
+// the order in which they were first set. Keys are identified by their
+// resolved tag and value (see mappingKey), so that the int key 1 and the
+// string key "1" are different entries.
+type orderedMapping struct {
+	keys   []*yaml.Node
+	values []*yaml.Node
+	index  map[string]int
+}
+
+func newOrderedMapping() *orderedMapping {
+	return &orderedMapping{
+		index: map[string]int{},
+	}
+}
+
+// Set adds a new entry, or replaces the value of an existing entry while
+// keeping its position.
+func (m *orderedMapping) Set(key *yaml.Node, value *yaml.Node) {
+	if i, ok := m.index[mappingKey(key)]; ok {
+		m.values[i] = value
+		return
+	}
+	m.index[mappingKey(key)] = len(m.keys)
+	m.keys = append(m.keys, key)
+	m.values = append(m.values, value)
+}
+
+func (m *orderedMapping) Get(key *yaml.Node) (*yaml.Node, bool) {
+	i, ok := m.index[mappingKey(key)]
+	if !ok {
+		return nil, false
+	}
+	return m.values[i], true
+}
+
+// Delete removes an entry, keeping the order of the remaining entries.
+func (m *orderedMapping) Delete(key *yaml.Node) {
+	k := mappingKey(key)
+	i, ok := m.index[k]
+	if !ok {
+		return
+	}
+	m.keys = append(m.keys[:i], m.keys[i+1:]...)
+	m.values = append(m.values[:i], m.values[i+1:]...)
+	delete(m.index, k)
+	for j := i; j < len(m.keys); j++ {
+		m.index[mappingKey(m.keys[j])] = j
+	}
+}
+
+// mappingKey identifies a mapping key by its resolved tag and value, for
+// scalars, or by its serialized form otherwise.
+func mappingKey(key *yaml.Node) string {
+	key = resolveAlias(key)
+	if key.Kind != yaml.ScalarNode {
+		return nodeSortKey(key)
+	}
+	return key.ShortTag() + ":" + key.Value
+}
+
+func (m *orderedMapping) Len() int {
+	return len(m.keys)
+}
+
+// Sort reorders the entries according to mode. Sorting is stable, so entries
+// comparing equal keep their insertion order.
+func (m *orderedMapping) Sort(mode sortMode) {
+	if mode == sortNone {
+		return
+	}
+	order := make([]int, len(m.keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := order[i], order[j]
+		if mode == sortByValue {
+			return compareNodes(m.values[a], m.values[b]) < 0
+		}
+		return compareNodes(m.keys[a], m.keys[b]) < 0
+	})
+
+	keys := make([]*yaml.Node, len(order))
+	values := make([]*yaml.Node, len(order))
+	for i, o := range order {
+		keys[i] = m.keys[o]
+		values[i] = m.values[o]
+		m.index[mappingKey(keys[i])] = i
+	}
+	m.keys = keys
+	m.values = values
+}
+
+// Node returns the entries as a mapping node.
+func (m *orderedMapping) Node() *yaml.Node {
+	content := make([]*yaml.Node, 0, len(m.keys)*2)
+	for i := range m.keys {
+		content = append(content, m.keys[i], m.values[i])
+	}
+	return &yaml.Node{
+		Kind:    yaml.MappingNode,
+		Tag:     "!!map",
+		Content: content,
+	}
+}
+
+type sortMode int
+
+const (
+	sortNone sortMode = iota
+	sortByKey
+	sortByValue
+)
+
+// parseSortMode parses the `sort` argument of tags producing mappings.
+// It accepts true (same as key), false, key or value.
+func parseSortMode(tag string, node *yaml.Node) (sortMode, error) {
+	if node == nil {
+		return sortNone, nil
+	}
+	if node.Kind == yaml.ScalarNode {
+		if b, ok := NodeToBool(node); ok {
+			if b {
+				return sortByKey, nil
+			}
+			return sortNone, nil
+		}
+		switch node.Value {
+		case "key":
+			return sortByKey, nil
+		case "value":
+			return sortByValue, nil
+		}
+	}
+	return sortNone, tagArgumentErrorf("%s 'sort' argument must be true, false, key or value", tag)
+}
+
+// compareNodes orders nodes for sorting. Numeric scalars are compared by value
+// and sort before other scalars, which are compared as strings. Collections
+// sort after scalars and are compared by their serialized form.
+func compareNodes(a *yaml.Node, b *yaml.Node) int {
+	aScalar, bScalar := a.Kind == yaml.ScalarNode, b.Kind == yaml.ScalarNode
+	switch {
+	case aScalar && !bScalar:
+		return -1
+	case !aScalar && bScalar:
+		return 1
+	case !aScalar && !bScalar:
+		return strings.Compare(nodeSortKey(a), nodeSortKey(b))
+	}
+
+	af, aErr := strconv.ParseFloat(a.Value, 64)
+	bf, bErr := strconv.ParseFloat(b.Value, 64)
+	aNumber, bNumber := aErr == nil && a.Tag != "!!str", bErr == nil && b.Tag != "!!str"
+	switch {
+	case aNumber && bNumber:
+		return cmp.Compare(af, bf)
+	case aNumber:
+		return -1
+	case bNumber:
+		return 1
+	}
+	return strings.Compare(a.Value, b.Value)
+}
+
+func nodeSortKey(node *yaml.Node) string {
+	b, err := yaml.Marshal(node)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
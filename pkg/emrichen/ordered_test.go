@@ -0,0 +1,210 @@
+package emrichen
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/go-go-golems/go-emrichen/pkg/env"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+// processToYAML processes all documents in input and returns their serialized output.
+func processToYAML(t *testing.T, ei *Interpreter, input string) string {
+	decoder := yaml.NewDecoder(strings.NewReader(input))
+	var sb strings.Builder
+	for {
+		node := &yaml.Node{}
+		err := decoder.Decode(ei.CreateRawDecoder(node))
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if node.Kind == 0 {
+			continue
+		}
+		b, err := yaml.Marshal(node)
+		require.NoError(t, err)
+		sb.Write(b)
+	}
+	return sb.String()
+}
+
+func TestOrderedOutput(t *testing.T) {
+	tests := []struct {
+		name      string
+		inputYAML string
+		expected  string
+	}{
+		{
+			name: "Merge keeps first-seen order",
+			inputYAML: `!Merge
+  - {b: 1, a: 2}
+  - {c: 3, b: 4}`,
+			expected: "b: 4\na: 2\nc: 3\n",
+		},
+		{
+			name: "Merge sorted by key",
+			inputYAML: `!Merge
+  items:
+    - {b: 1, a: 2}
+    - {c: 3, b: 4}
+  sort: true`,
+			expected: "a: 2\nb: 4\nc: 3\n",
+		},
+		{
+			name: "Merge sorted by value",
+			inputYAML: `!Merge
+  items:
+    - {b: 1, a: 2}
+    - {c: 3, b: 4}
+  sort: value`,
+			expected: "a: 2\nc: 3\nb: 4\n",
+		},
+		{
+			name: "Group keeps first-seen order",
+			inputYAML: `!Group
+  over: [{k: z, v: 1}, {k: a, v: 2}, {k: z, v: 3}]
+  by: !Lookup item.k
+  template: !Lookup item.v`,
+			expected: "z:\n    - 1\n    - 3\na:\n    - 2\n",
+		},
+		{
+			name: "Group sorted by key",
+			inputYAML: `!Group
+  over: [{k: z, v: 1}, {k: a, v: 2}, {k: z, v: 3}]
+  by: !Lookup item.k
+  template: !Lookup item.v
+  sort: key`,
+			expected: "a:\n    - 2\nz:\n    - 1\n    - 3\n",
+		},
+		{
+			name: "Index keeps first-seen order",
+			inputYAML: `!Index
+  over: [{name: web, port: 80}, {name: api, port: 8080}, {name: db, port: 5432}]
+  by: !Lookup item.name
+  template: !Lookup item.port`,
+			expected: "web: 80\napi: 8080\ndb: 5432\n",
+		},
+		{
+			name: "Index sorted by numeric value",
+			inputYAML: `!Index
+  over: [{name: web, port: 80}, {name: api, port: 8080}, {name: db, port: 5432}]
+  by: !Lookup item.name
+  template: !Lookup item.port
+  sort: value`,
+			expected: "web: 80\ndb: 5432\napi: 8080\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ei, err := NewInterpreter()
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, processToYAML(t, ei, tc.inputYAML))
+		})
+	}
+}
+
+func TestInvalidSortArgument(t *testing.T) {
+	ei, err := NewInterpreter()
+	require.NoError(t, err)
+
+	var result interface{}
+	err = yaml.Unmarshal([]byte(`!Merge {items: [{a: 1}], sort: sideways}`), ei.CreateDecoder(&result))
+	require.Error(t, err)
+	assert.ErrorIs(t, err, ErrTagArgument)
+}
+
+func TestProcessingIsDeterministic(t *testing.T) {
+	vars := map[string]interface{}{}
+	items := []interface{}{}
+	for i := 0; i < 50; i++ {
+		vars[fmt.Sprintf("key%d", i)] = i
+		items = append(items, map[string]interface{}{
+			"name":  fmt.Sprintf("item%d", i),
+			"group": fmt.Sprintf("group%d", i%7),
+		})
+	}
+
+	input := `
+vars: !Var vars
+merged: !Merge
+  - !Var vars
+  - {extra: 1, key3: overridden}
+grouped: !Group
+  over: !Var items
+  by: !Lookup item.group
+indexed: !Index
+  over: !Var items
+  by: !Lookup item.name
+`
+
+	var first string
+	for i := 0; i < 20; i++ {
+		ei, err := NewInterpreter(WithVars(map[string]interface{}{
+			"vars":  vars,
+			"items": items,
+		}))
+		require.NoError(t, err)
+
+		output := processToYAML(t, ei, input)
+		if i == 0 {
+			first = output
+			continue
+		}
+		require.Equal(t, first, output, "output differs on run %d", i)
+	}
+}
+
+func TestOrderedMapVariables(t *testing.T) {
+	ports := env.NewOrderedMap()
+	ports.Set("https", 443)
+	ports.Set("http", 80)
+	ports.Set("admin", 8080)
+
+	ei, err := NewInterpreter(WithVars(map[string]interface{}{
+		"service": map[string]interface{}{"ports": ports},
+	}))
+	require.NoError(t, err)
+
+	assert.Equal(t, `ports:
+    https: 443
+    http: 80
+    admin: 8080
+names:
+    https: https
+    http: http
+    admin: admin
+format: 80 443
+same: true
+`, processToYAML(t, ei, `
+ports: !Lookup service.ports
+names: !Loop
+  over: !Lookup service.ports
+  index_as: name
+  template: !Var name
+format: !Format "{{ .service.ports.http }} {{ index .service.ports \"https\" }}"
+same: !Op
+  a: !Lookup service.ports
+  op: ==
+  b: {admin: 8080, http: 80, https: 443}
+`))
+}
+
+func TestValueToNodeOrderedMap(t *testing.T) {
+	m := env.NewOrderedMap()
+	m.Set("z", 1)
+	m.Set("a", []interface{}{env.NewOrderedMap()})
+
+	node, err := ValueToNode(m)
+	require.NoError(t, err)
+	assert.Equal(t, "z: 1\na:\n    - {}\n", marshalNode(t, node))
+
+	converted, ok := NodeToMap(node)
+	require.True(t, ok)
+	assert.Equal(t, []string{"z", "a"}, converted.Keys())
+}
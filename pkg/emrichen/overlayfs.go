@@ -0,0 +1,166 @@
+package emrichen
+
+import (
+	"io"
+	"io/fs"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// overlayFS is a union of filesystems, where files of earlier layers shadow
+// files with the same path in later layers.
+type overlayFS struct {
+	layers []fs.FS
+}
+
+var (
+	_ fs.StatFS     = (*overlayFS)(nil)
+	_ fs.ReadDirFS  = (*overlayFS)(nil)
+	_ fs.ReadFileFS = (*overlayFS)(nil)
+)
+
+// NewOverlayFS returns a filesystem combining layers. A file is read from the
+// first layer containing it, and directories list the entries of all layers.
+// This makes it possible to shadow embedded default templates with local
+// files:
+//
+//	fsys := emrichen.NewOverlayFS(os.DirFS("overrides"), embeddedTemplates)
+//	ei, err := emrichen.NewInterpreter(emrichen.WithFS(fsys))
+func NewOverlayFS(layers ...fs.FS) fs.FS {
+	return &overlayFS{layers: layers}
+}
+
+// firstLayer calls f for each layer until it returns an error other than
+// fs.ErrNotExist.
+func (o *overlayFS) firstLayer(op string, name string, f func(layer fs.FS) error) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	for _, layer := range o.layers {
+		err := f(layer)
+		if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+// Open opens the file from the first layer containing it. Directories list the
+// entries of all layers.
+func (o *overlayFS) Open(name string) (fs.File, error) {
+	var file fs.File
+	err := o.firstLayer("open", name, func(layer fs.FS) error {
+		var err error
+		file, err = layer.Open(name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		return &overlayDir{File: file, fsys: o, name: name}, nil
+	}
+	return file, nil
+}
+
+func (o *overlayFS) Stat(name string) (fs.FileInfo, error) {
+	var info fs.FileInfo
+	err := o.firstLayer("stat", name, func(layer fs.FS) error {
+		var err error
+		info, err = fs.Stat(layer, name)
+		return err
+	})
+	return info, err
+}
+
+func (o *overlayFS) ReadFile(name string) ([]byte, error) {
+	var content []byte
+	err := o.firstLayer("read", name, func(layer fs.FS) error {
+		var err error
+		content, err = fs.ReadFile(layer, name)
+		return err
+	})
+	return content, err
+}
+
+// ReadDir returns the union of the entries of name in all layers, sorted by
+// file name. Entries of earlier layers shadow entries of later layers.
+func (o *overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	found := false
+	entries := map[string]fs.DirEntry{}
+	for _, layer := range o.layers {
+		layerEntries, err := fs.ReadDir(layer, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		found = true
+		for _, entry := range layerEntries {
+			if _, ok := entries[entry.Name()]; !ok {
+				entries[entry.Name()] = entry
+			}
+		}
+	}
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	ret := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		ret = append(ret, entry)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name() < ret[j].Name()
+	})
+	return ret, nil
+}
+
+// overlayDir is a directory of an overlayFS, listing the entries of all layers.
+type overlayDir struct {
+	fs.File
+	fsys    *overlayFS
+	name    string
+	entries []fs.DirEntry
+	read    bool
+}
+
+var _ fs.ReadDirFile = (*overlayDir)(nil)
+
+func (d *overlayDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, err := d.fsys.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = entries
+		d.read = true
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
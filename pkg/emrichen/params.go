@@ -0,0 +1,280 @@
+package emrichen
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ParamType is the type of a template parameter declared with !Params.
+type ParamType string
+
+const (
+	ParamTypeAny    ParamType = "any"
+	ParamTypeString ParamType = "string"
+	ParamTypeInt    ParamType = "int"
+	ParamTypeFloat  ParamType = "float"
+	ParamTypeBool   ParamType = "bool"
+	ParamTypeList   ParamType = "list"
+	ParamTypeMap    ParamType = "map"
+)
+
+var paramTypes = []ParamType{
+	ParamTypeAny, ParamTypeString, ParamTypeInt, ParamTypeFloat, ParamTypeBool, ParamTypeList, ParamTypeMap,
+}
+
+// Param is a template parameter declared with !Params.
+type Param struct {
+	Name string
+	Type ParamType
+	// Default is the unevaluated default value, nil if there is none.
+	Default     *yaml.Node
+	Description string
+	// Enum lists the allowed values, if not empty.
+	Enum []*yaml.Node
+	// Required parameters must be supplied by the caller, unless they have a
+	// default.
+	Required bool
+}
+
+var paramArgs = []ParsedVariable{
+	{Name: "type", Expand: true},
+	// defaults are evaluated lazily, like !Defaults
+	{Name: "default"},
+	{Name: "description", Expand: true},
+	{Name: "enum", Expand: true},
+	{Name: "required", Expand: true},
+}
+
+// handleParams declares the parameters of a template. The defaults of the
+// parameters are set like with !Defaults, then the variables are validated
+// against the declarations: required parameters must be set, and values must
+// match their type and enum. Strings are converted to the declared scalar
+// type if possible (for example "3" to an int), and scalars to strings.
+func (ei *Interpreter) handleParams(node *yaml.Node) (*yaml.Node, error) {
+	params, err := ei.parseParams(node)
+	if err != nil {
+		return nil, err
+	}
+
+	defaults := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, param := range params {
+		if param.Default != nil {
+			defaults.Content = append(defaults.Content, makeString(param.Name), param.Default)
+		}
+	}
+	err = ei.defineDefaults(defaults, "!Params")
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, ei.validateParams(params)
+}
+
+// parseParams parses the declarations of a !Params mapping. The declaration
+// of a parameter is either a mapping with the keys type, default,
+// description, enum and required, or just its type.
+func (ei *Interpreter) parseParams(node *yaml.Node) ([]Param, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, tagArgumentErrorf("!Params requires a mapping of parameter declarations")
+	}
+
+	var params []Param
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		param := Param{Name: node.Content[i].Value, Type: ParamTypeAny}
+		spec := node.Content[i+1]
+
+		switch {
+		case spec.Kind == yaml.ScalarNode && spec.Tag == "!!null":
+		case spec.Kind == yaml.ScalarNode:
+			param.Type = ParamType(spec.Value)
+		case spec.Kind == yaml.MappingNode:
+			args, err := ei.ParseArgs(spec, paramArgs)
+			if err != nil {
+				return nil, tagArgumentErrorf("parameter %s: %s", param.Name, err)
+			}
+			if typeNode, ok := args["type"]; ok {
+				param.Type = ParamType(typeNode.Value)
+			}
+			param.Default = args["default"]
+			if description, ok := args["description"]; ok {
+				param.Description = description.Value
+			}
+			if enum, ok := args["enum"]; ok {
+				if enum.Kind != yaml.SequenceNode {
+					return nil, tagArgumentErrorf("parameter %s: enum must be a list", param.Name)
+				}
+				param.Enum = enum.Content
+			}
+			if required, ok := args["required"]; ok {
+				param.Required, ok = NodeToBool(required)
+				if !ok {
+					return nil, tagArgumentErrorf("parameter %s: required must be a boolean", param.Name)
+				}
+			}
+		default:
+			return nil, tagArgumentErrorf("parameter %s: expected a type or a mapping", param.Name)
+		}
+
+		if !isParamType(param.Type) {
+			return nil, tagArgumentErrorf("parameter %s: unknown type '%s'", param.Name, param.Type)
+		}
+		params = append(params, param)
+	}
+
+	return params, nil
+}
+
+func isParamType(t ParamType) bool {
+	for _, paramType := range paramTypes {
+		if t == paramType {
+			return true
+		}
+	}
+	return false
+}
+
+// validateParams checks the variables against params, and reports all the
+// problems found in a single *ParamsError.
+func (ei *Interpreter) validateParams(params []Param) error {
+	var problems []string
+	converted := map[string]interface{}{}
+	for _, param := range params {
+		v, ok, err := ei.env.ResolveVar(param.Name)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if param.Required {
+				problems = append(problems, fmt.Sprintf("%s is required", param.Name))
+			}
+			continue
+		}
+
+		node, err := ValueToNode(v)
+		if err != nil {
+			return err
+		}
+		value, ok := convertParam(param.Type, node)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s: expected %s, got %s", param.Name, param.Type, describeNode(node)))
+			continue
+		}
+		if len(param.Enum) > 0 && !inEnum(value, param.Enum) {
+			allowed := make([]string, 0, len(param.Enum))
+			for _, e := range param.Enum {
+				allowed = append(allowed, describeNode(e))
+			}
+			problems = append(problems, fmt.Sprintf("%s: expected one of %s, got %s",
+				param.Name, strings.Join(allowed, ", "), describeNode(value)))
+			continue
+		}
+		if value != node {
+			converted[param.Name] = value
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ParamsError{Problems: problems}
+	}
+	if len(converted) > 0 {
+		ei.pushCallerVars(converted)
+	}
+	return nil
+}
+
+// convertParam returns node, or node converted to t, and false if it doesn't
+// match t.
+func convertParam(t ParamType, node *yaml.Node) (*yaml.Node, bool) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	tag := node.ShortTag()
+	isString := node.Kind == yaml.ScalarNode && tag == "!!str"
+
+	switch t {
+	case ParamTypeString:
+		if node.Kind != yaml.ScalarNode || tag == "!!null" {
+			return nil, false
+		}
+		if !isString {
+			return makeString(node.Value), true
+		}
+	case ParamTypeInt:
+		if node.Kind == yaml.ScalarNode && tag == "!!int" {
+			return node, true
+		}
+		if i, err := strconv.Atoi(node.Value); isString && err == nil {
+			return makeInt(i), true
+		}
+		return nil, false
+	case ParamTypeFloat:
+		if node.Kind == yaml.ScalarNode && (tag == "!!float" || tag == "!!int") {
+			return node, true
+		}
+		if f, err := strconv.ParseFloat(node.Value, 64); isString && err == nil {
+			return makeFloat(f), true
+		}
+		return nil, false
+	case ParamTypeBool:
+		if node.Kind == yaml.ScalarNode && tag == "!!bool" {
+			return node, true
+		}
+		if b, err := strconv.ParseBool(node.Value); isString && err == nil {
+			return makeBool(b), true
+		}
+		return nil, false
+	case ParamTypeList:
+		return node, node.Kind == yaml.SequenceNode
+	case ParamTypeMap:
+		return node, node.Kind == yaml.MappingNode
+	case ParamTypeAny:
+	}
+	return node, true
+}
+
+func inEnum(value *yaml.Node, enum []*yaml.Node) bool {
+	for _, e := range enum {
+		if valuesEqual(value, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// describeNode formats a value for error messages.
+func describeNode(node *yaml.Node) string {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	switch node.Kind {
+	case yaml.SequenceNode:
+		return "a list"
+	case yaml.MappingNode:
+		return "a map"
+	default:
+		if node.ShortTag() == "!!str" {
+			return strconv.Quote(node.Value)
+		}
+		return node.Value
+	}
+}
+
+// documentParams returns the parameters declared by a document, if it is a
+// !Params document.
+func (ei *Interpreter) documentParams(document *yaml.Node) ([]Param, error) {
+	root := document
+	if root.Kind == yaml.DocumentNode && len(root.Content) == 1 {
+		root = root.Content[0]
+	}
+	if root.Tag != "!Params" {
+		return nil, nil
+	}
+	params, err := ei.parseParams(root)
+	if err != nil {
+		return nil, ei.wrapError(err, "!Params", root)
+	}
+	return params, nil
+}
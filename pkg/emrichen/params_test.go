@@ -0,0 +1,140 @@
+package emrichen
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestEmrichenParamsTag(t *testing.T) {
+	params := `
+!Params
+image:
+  type: string
+  required: true
+  description: Image to deploy
+replicas:
+  type: int
+  default: 2
+env:
+  type: string
+  enum: [dev, prod]
+  default: dev
+debug: bool
+---
+`
+	tests := []testCase{
+		{
+			name:      "Defaults Are Applied",
+			inputYAML: params + "[!Var image, !Var replicas, !Var env, !Exists debug]",
+			initVars:  map[string]interface{}{"image": "nginx"},
+			expected:  "[nginx, 2, dev, false]",
+		},
+		{
+			name:      "Caller Values Override Defaults",
+			inputYAML: params + "[!Var replicas, !Var env, !Var debug]",
+			initVars:  map[string]interface{}{"image": "nginx", "replicas": 3, "env": "prod", "debug": true},
+			expected:  "[3, prod, true]",
+		},
+		{
+			name:      "Strings Are Converted",
+			inputYAML: params + "[!Var replicas, !Var debug, !Op {a: !Var replicas, op: '+', b: 1}]",
+			initVars:  map[string]interface{}{"image": "nginx", "replicas": "5", "debug": "true"},
+			expected:  "[5, true, 6]",
+		},
+		{
+			name:               "Missing Required Parameter",
+			inputYAML:          params + "image: !Var image",
+			expectError:        true,
+			expectErrorMessage: "invalid parameters: image is required",
+		},
+		{
+			name:               "Invalid Values Are All Reported",
+			inputYAML:          params + "image: !Var image",
+			initVars:           map[string]interface{}{"image": []interface{}{1}, "replicas": "many", "env": "staging"},
+			expectError:        true,
+			expectErrorMessage: `invalid parameters: image: expected string, got a list; replicas: expected int, got "many"; env: expected one of "dev", "prod", got "staging"`,
+		},
+		{
+			name: "Defaults Can Refer To Other Parameters",
+			inputYAML: `
+!Params
+name: {type: string, default: app}
+fullname: {type: string, default: !Format "{name}-svc"}
+---
+fullname: !Var fullname`,
+			initVars: map[string]interface{}{"name": "api"},
+			expected: "fullname: api-svc",
+		},
+		{
+			name:               "Unknown Type",
+			inputYAML:          "!Params\nx: integer\n",
+			expectError:        true,
+			expectErrorMessage: "parameter x: unknown type 'integer'",
+		},
+		{
+			name:               "Unknown Declaration Key",
+			inputYAML:          "!Params\nx: {type: int, requird: true}\n",
+			expectError:        true,
+			expectErrorMessage: "parameter x: unknown key 'requird'",
+		},
+	}
+
+	runTests(t, tests)
+}
+
+func TestParamsError(t *testing.T) {
+	ei, err := NewInterpreter()
+	require.NoError(t, err)
+
+	_, err = ei.Process(parseNode(t, "!Params {a: {required: true}, b: {required: true}}"))
+	require.ErrorIs(t, err, ErrInvalidParams)
+	var paramsErr *ParamsError
+	require.ErrorAs(t, err, &paramsErr)
+	assert.Equal(t, []string{"a is required", "b is required"}, paramsErr.Problems)
+}
+
+func TestTemplateParams(t *testing.T) {
+	tmpl, err := Compile(strings.NewReader(`
+!Params
+replicas:
+  type: int
+  default: 1
+  description: Number of replicas
+env:
+  type: string
+  enum: [dev, prod]
+  required: true
+---
+replicas: !Var replicas
+env: !Var env
+`))
+	require.NoError(t, err)
+
+	params := tmpl.Params()
+	require.Len(t, params, 2)
+	assert.Equal(t, "replicas", params[0].Name)
+	assert.Equal(t, ParamTypeInt, params[0].Type)
+	assert.Equal(t, "Number of replicas", params[0].Description)
+	require.NotNil(t, params[0].Default)
+	assert.Equal(t, "1", params[0].Default.Value)
+	assert.Equal(t, "env", params[1].Name)
+	assert.True(t, params[1].Required)
+	require.Len(t, params[1].Enum, 2)
+
+	documents, err := tmpl.Render(map[string]interface{}{"env": "prod"})
+	require.NoError(t, err)
+	require.Len(t, documents, 1)
+	out, err := yaml.Marshal(documents[0])
+	require.NoError(t, err)
+	assert.Equal(t, "replicas: 1\nenv: prod\n", string(out))
+
+	_, err = tmpl.Render(nil)
+	assert.ErrorIs(t, err, ErrInvalidParams)
+
+	_, err = Compile(strings.NewReader("!Params\nx: {type: nope}\n"))
+	assert.ErrorIs(t, err, ErrTagArgument)
+}
@@ -1,7 +1,6 @@
 package emrichen
 
 import (
-	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
 )
 
@@ -47,7 +46,7 @@ func (ei *Interpreter) ParseArgs(
 ) (map[string]*yaml.Node, error) {
 	argsMap := make(map[string]*yaml.Node)
 	if node.Kind != yaml.MappingNode {
-		return nil, errors.New("expected a mapping node")
+		return nil, tagArgumentErrorf("expected a mapping node")
 	}
 
 	varMap := make(map[string]ParsedVariable)
@@ -55,16 +54,21 @@ func (ei *Interpreter) ParseArgs(
 		varMap[v.Name] = v
 	}
 
-	for i := 0; i < len(node.Content); i += 2 {
-		keyNode := node.Content[i]
-		valueNode := node.Content[i+1]
+	content, err := ei.expandMergeKeys(node)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < len(content); i += 2 {
+		keyNode := content[i]
+		valueNode := content[i+1]
 		parsedVar, ok := varMap[keyNode.Value]
 		if !ok {
-			return nil, errors.Errorf("unknown key '%s'", keyNode.Value)
+			return nil, tagArgumentErrorf("unknown key '%s'", keyNode.Value)
 		}
 		key, ok := NodeToString(keyNode)
 		if !ok {
-			return nil, errors.Errorf("expected scalar key '%s'", keyNode.Value)
+			return nil, tagArgumentErrorf("expected scalar key '%s'", keyNode.Value)
 		}
 
 		if parsedVar.Expand {
@@ -80,7 +84,7 @@ func (ei *Interpreter) ParseArgs(
 	for _, v := range variables {
 		if v.Required {
 			if _, ok := argsMap[v.Name]; !ok {
-				return nil, errors.Errorf("required key '%s' not found", v.Name)
+				return nil, tagArgumentErrorf("required key '%s' not found", v.Name)
 			}
 		}
 	}
@@ -88,6 +92,11 @@ func (ei *Interpreter) ParseArgs(
 	return argsMap, nil
 }
 
+var urlEncodeArgs = []ParsedVariable{
+	{Name: "url", Required: true, Expand: true},
+	{Name: "query", Expand: true},
+}
+
 // parseURLEncodeArgs extracts 'url' and 'query' parameters from a YAML node and organizes them suitably for URL encoding.
 // This function is specifically tailored for extracting URL and query parameters for URL encoding purposes.
 //
@@ -101,17 +110,14 @@ func (ei *Interpreter) ParseArgs(
 //
 // Note: The 'query' parameter is optional and can be a mapping node containing key-value pairs of query parameters.
 func (ei *Interpreter) parseURLEncodeArgs(node *yaml.Node) (string, map[string]interface{}, error) {
-	args, err := ei.ParseArgs(node, []ParsedVariable{
-		{Name: "url", Required: true, Expand: true},
-		{Name: "query", Expand: true},
-	})
+	args, err := ei.ParseArgs(node, urlEncodeArgs)
 	if err != nil {
 		return "", nil, err
 	}
 
 	urlStr, ok := NodeToString(args["url"])
 	if !ok {
-		return "", nil, errors.New("url must be a string")
+		return "", nil, tagArgumentErrorf("url must be a string")
 	}
 
 	// TODO need to process node
@@ -125,7 +131,7 @@ func (ei *Interpreter) parseURLEncodeArgs(node *yaml.Node) (string, map[string]i
 			}
 			paramValue, ok := NodeToScalarInterface(param)
 			if !ok {
-				return "", nil, errors.New("query parameter value must be a scalar")
+				return "", nil, tagArgumentErrorf("query parameter value must be a scalar")
 			}
 			queryParams[paramKey] = paramValue
 		}
@@ -0,0 +1,283 @@
+package emrichen
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// DangerousFuncs are template functions denied by DefaultSandbox, because
+// they give templates access to the environment or the network.
+var DangerousFuncs = []string{"env", "expandenv", "getHostByName"}
+
+// Sandbox restricts what templates can access, for rendering untrusted
+// templates. Violations are reported as *SandboxError.
+type Sandbox struct {
+	// IncludeRoot is the directory the !Include* tags are confined to.
+	// Paths escaping it, including through symlinks, are rejected. If it is
+	// empty, including files is denied.
+	//
+	// IncludeRoot is ignored if the interpreter reads files from an fs.FS set
+	// with WithFS: the fs.FS is used as is, and the caller is responsible for
+	// its confinement. os.DirFS, for example, follows symlinks out of its
+	// directory, while os.Root.FS doesn't.
+	IncludeRoot string
+	// AllowedTags lists the only tags templates may use. If it is empty,
+	// all tags not listed in DeniedTags are allowed.
+	AllowedTags []string
+	// DeniedTags lists tags templates may not use.
+	DeniedTags []string
+	// AllowedFuncs lists the only functions of the funcmaps passed with
+	// WithFuncMap that templates may call. If it is empty, all functions not
+	// listed in DeniedFuncs are allowed. The built-in lookup, lookupAll and
+	// exists functions are always allowed.
+	AllowedFuncs []string
+	// DeniedFuncs lists the template functions templates may not call.
+	DeniedFuncs []string
+}
+
+// DefaultSandbox returns a sandbox confining includes to includeRoot and
+// denying DangerousFuncs.
+func DefaultSandbox(includeRoot string) Sandbox {
+	return Sandbox{
+		IncludeRoot: includeRoot,
+		DeniedFuncs: DangerousFuncs,
+	}
+}
+
+// WithSandbox restricts the interpreter according to sandbox.
+func WithSandbox(sandbox Sandbox) InterpreterOption {
+	return func(ei *Interpreter) error {
+		ei.sandbox = &sandbox
+		return nil
+	}
+}
+
+// applySandbox confines the filesystem of the interpreter. It is called once
+// all options have been applied, so that it can take WithFS into account.
+func (ei *Interpreter) applySandbox() error {
+	if ei.sandbox == nil {
+		return nil
+	}
+	if _, ok := ei.fs.(osFileSystem); !ok {
+		// the caller is responsible for the confinement of an fs.FS
+		return nil
+	}
+	if ei.sandbox.IncludeRoot == "" {
+		ei.fs = deniedFileSystem{}
+		return nil
+	}
+
+	confined, err := newConfinedFileSystem(ei.sandbox.IncludeRoot)
+	if err != nil {
+		return err
+	}
+	ei.fs = confined
+	return nil
+}
+
+// checkTagAllowed returns a *SandboxError if the sandbox doesn't allow tag.
+func (ei *Interpreter) checkTagAllowed(tag string) error {
+	if ei.sandbox == nil {
+		return nil
+	}
+	if !nameAllowed(tag, ei.sandbox.AllowedTags, ei.sandbox.DeniedTags) {
+		return sandboxErrorf("tag %s is not allowed", tag)
+	}
+	return nil
+}
+
+// sandboxFuncMap returns funcmap without the functions the sandbox doesn't
+// allow. They are replaced by functions returning a *SandboxError, so that
+// calling them fails with a typed error instead of a parse error.
+func (ei *Interpreter) sandboxFuncMap(funcmap template.FuncMap) template.FuncMap {
+	if ei.sandbox == nil {
+		return funcmap
+	}
+	ret := make(template.FuncMap, len(funcmap))
+	for name, f := range funcmap {
+		if nameAllowed(name, ei.sandbox.AllowedFuncs, ei.sandbox.DeniedFuncs) {
+			ret[name] = f
+			continue
+		}
+		name := name
+		ret[name] = func(...interface{}) (interface{}, error) {
+			return nil, sandboxErrorf("template function %s is not allowed", name)
+		}
+	}
+	return ret
+}
+
+func nameAllowed(name string, allowed []string, denied []string) bool {
+	for _, d := range denied {
+		if d == name {
+			return false
+		}
+	}
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		if a == name {
+			return true
+		}
+	}
+	return false
+}
+
+// deniedFileSystem is used in a sandbox without include root, and denies all
+// file access.
+type deniedFileSystem struct {
+	osFileSystem
+}
+
+var _ fileSystem = deniedFileSystem{}
+
+func (deniedFileSystem) Open(name string) (fs.File, error) {
+	return nil, sandboxErrorf("including %s is not allowed, no include root is configured", name)
+}
+
+func (deniedFileSystem) Stat(name string) (fs.FileInfo, error) {
+	return nil, sandboxErrorf("including %s is not allowed, no include root is configured", name)
+}
+
+func (deniedFileSystem) Glob(pattern string) ([]string, error) {
+	return nil, sandboxErrorf("including %s is not allowed, no include root is configured", pattern)
+}
+
+// confinedFileSystem uses the operating system's paths, but only gives
+// access to files below a root directory. Files are opened through an
+// os.Root, which also refuses symlinks escaping the root. The root is opened
+// for each access, so that interpreters don't hold a file descriptor.
+type confinedFileSystem struct {
+	osFileSystem
+	rootPath string
+}
+
+var _ fileSystem = confinedFileSystem{}
+
+func newConfinedFileSystem(rootPath string) (confinedFileSystem, error) {
+	rootPath, err := filepath.Abs(rootPath)
+	if err != nil {
+		return confinedFileSystem{}, errors.Wrap(err, "could not resolve include root")
+	}
+	rootPath, err = filepath.EvalSymlinks(rootPath)
+	if err != nil {
+		return confinedFileSystem{}, errors.Wrap(err, "could not resolve include root")
+	}
+	ret := confinedFileSystem{rootPath: rootPath}
+	// report a missing root when creating the interpreter
+	err = ret.withRoot(func(*os.Root) error {
+		return nil
+	})
+	if err != nil {
+		return confinedFileSystem{}, err
+	}
+	return ret, nil
+}
+
+// withRoot calls f with the root opened. Files opened by f stay open once the
+// root is closed.
+func (c confinedFileSystem) withRoot(f func(root *os.Root) error) error {
+	root, err := os.OpenRoot(c.rootPath)
+	if err != nil {
+		return errors.Wrap(err, "could not open include root")
+	}
+	defer func() {
+		_ = root.Close()
+	}()
+	return f(root)
+}
+
+// rel returns the path of name relative to the root, or a *SandboxError if
+// it is outside of the root, lexically or through symlinks.
+func (c confinedFileSystem) rel(name string) (string, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+	rel, ok := relativeTo(c.rootPath, abs)
+	if !ok {
+		return "", sandboxErrorf("path %s escapes the include root", name)
+	}
+
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		// let os.Root report missing files
+		return rel, nil
+	}
+	if _, ok := relativeTo(c.rootPath, resolved); !ok {
+		return "", sandboxErrorf("path %s escapes the include root through a symlink", name)
+	}
+	return rel, nil
+}
+
+// relativeTo returns path relative to dir, if it is inside of dir.
+func relativeTo(dir string, path string) (string, bool) {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+func (c confinedFileSystem) Open(name string) (fs.File, error) {
+	rel, err := c.rel(name)
+	if err != nil {
+		return nil, err
+	}
+	var ret *os.File
+	err = c.withRoot(func(root *os.Root) error {
+		ret, err = root.Open(rel)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (c confinedFileSystem) Stat(name string) (fs.FileInfo, error) {
+	rel, err := c.rel(name)
+	if err != nil {
+		return nil, err
+	}
+	var ret fs.FileInfo
+	err = c.withRoot(func(root *os.Root) error {
+		ret, err = root.Stat(rel)
+		return err
+	})
+	return ret, err
+}
+
+// Glob matches pattern below the root only. The pattern is rejected if it
+// isn't below the root, without listing anything: since it is cleaned, this
+// covers its non-magic prefix. Matching then reads directories through the
+// root, so that symlinks can't list directories outside of it.
+func (c confinedFileSystem) Glob(pattern string) ([]string, error) {
+	abs, err := filepath.Abs(pattern)
+	if err != nil {
+		return nil, err
+	}
+	rel, ok := relativeTo(c.rootPath, abs)
+	if !ok {
+		return nil, sandboxErrorf("glob pattern %s escapes the include root", pattern)
+	}
+
+	var matches []string
+	err = c.withRoot(func(root *os.Root) error {
+		matches, err = fs.Glob(root.FS(), filepath.ToSlash(rel))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, match := range matches {
+		matches[i] = filepath.Join(c.rootPath, filepath.FromSlash(match))
+	}
+	return matches, nil
+}
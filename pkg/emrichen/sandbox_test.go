@@ -0,0 +1,171 @@
+package emrichen
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSandboxIncludeRoot(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	writeFiles(t, dir, map[string]string{
+		"root/app/main.yml":      "!Include ../common/labels.yml\n",
+		"root/common/labels.yml": "team: platform\n",
+		"secret.txt":             "hunter2",
+	})
+	require.NoError(t, os.Symlink(filepath.Join(dir, "secret.txt"), filepath.Join(root, "link.txt")))
+
+	ei, err := NewInterpreter(WithSandbox(DefaultSandbox(root)))
+	require.NoError(t, err)
+	ei.SetSourceFile(filepath.Join(root, "app", "root.yml"))
+
+	output := processToYAML(t, ei, "!Include main.yml")
+	assert.Equal(t, "team: platform\n", output)
+
+	for _, input := range []string{
+		"!IncludeText ../../secret.txt",
+		"!IncludeText " + filepath.Join(dir, "secret.txt"),
+		"!IncludeText ../link.txt",
+		"!IncludeGlob ../../*.txt",
+	} {
+		_, err = ei.Process(parseNode(t, input))
+		assert.True(t, errors.Is(err, ErrSandboxViolation), input)
+
+		var sandboxErr *SandboxError
+		assert.True(t, errors.As(err, &sandboxErr), input)
+	}
+}
+
+func TestSandboxIncludeGlob(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	writeFiles(t, dir, map[string]string{
+		"root/app/a.yml":     "a: 1\n",
+		"root/app/b.yml":     "b: 2\n",
+		"outside/secret.yml": "secret: hunter2\n",
+	})
+	require.NoError(t, os.Symlink(filepath.Join(dir, "outside"), filepath.Join(root, "link")))
+
+	ei, err := NewInterpreter(WithSandbox(DefaultSandbox(root)))
+	require.NoError(t, err)
+	ei.SetSourceFile(filepath.Join(root, "main.yml"))
+
+	assert.Equal(t, "- a: 1\n- b: 2\n", processToYAML(t, ei, "!IncludeGlob app/*.yml"))
+	assert.Equal(t, "- a: 1\n", processToYAML(t, ei, "!IncludeGlob "+filepath.Join(root, "app", "a.*")))
+
+	for _, pattern := range []string{
+		filepath.Join(dir, "outside", "*.yml"),
+		"../outside/*.yml",
+		"../*/*.yml",
+		"app/../../*/*.yml",
+	} {
+		_, err = ei.Process(parseNode(t, "!IncludeGlob "+pattern))
+		assert.ErrorIs(t, err, ErrSandboxViolation, pattern)
+		assert.NotContains(t, err.Error(), "secret", pattern)
+	}
+
+	// directories outside of the root are not listed through symlinks
+	assert.Equal(t, "[]\n", processToYAML(t, ei, "!IncludeGlob link/*.yml"))
+}
+
+func TestSandboxIncludeRootErrors(t *testing.T) {
+	_, err := NewInterpreter(WithSandbox(DefaultSandbox(filepath.Join(t.TempDir(), "missing"))))
+	assert.ErrorContains(t, err, "could not resolve include root")
+
+	file := filepath.Join(t.TempDir(), "file.txt")
+	require.NoError(t, os.WriteFile(file, []byte("a"), 0644))
+	_, err = NewInterpreter(WithSandbox(DefaultSandbox(file)))
+	assert.ErrorContains(t, err, "could not open include root")
+}
+
+func TestSandboxDoesNotHoldFileDescriptors(t *testing.T) {
+	if _, err := os.Stat("/proc/self/fd"); err != nil {
+		t.Skip("open file descriptors can't be counted")
+	}
+	countFDs := func() int {
+		entries, err := os.ReadDir("/proc/self/fd")
+		require.NoError(t, err)
+		return len(entries)
+	}
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.yml": "a: 1\n"})
+	before := countFDs()
+	for i := 0; i < 20; i++ {
+		ei, err := NewInterpreter(WithSandbox(DefaultSandbox(dir)))
+		require.NoError(t, err)
+		ei.SetSourceFile(filepath.Join(dir, "root.yml"))
+		assert.Equal(t, "a: 1\n", processToYAML(t, ei, "!Include a.yml"))
+	}
+	assert.LessOrEqual(t, countFDs(), before)
+}
+
+func TestSandboxWithoutIncludeRoot(t *testing.T) {
+	ei, err := NewInterpreter(WithSandbox(Sandbox{}))
+	require.NoError(t, err)
+
+	_, err = ei.Process(parseNode(t, "!IncludeText test-data/text_test.txt"))
+	assert.True(t, errors.Is(err, ErrSandboxViolation))
+
+	// an fs.FS is confined by itself
+	ei, err = NewInterpreter(
+		WithSandbox(Sandbox{}),
+		WithFS(fstest.MapFS{"a.txt": {Data: []byte("a")}}))
+	require.NoError(t, err)
+
+	output := processToYAML(t, ei, "!IncludeText a.txt")
+	assert.Equal(t, "a\n", output)
+}
+
+func TestSandboxTags(t *testing.T) {
+	ei, err := NewInterpreter(WithSandbox(Sandbox{DeniedTags: []string{"!Include", "!IncludeText"}}))
+	require.NoError(t, err)
+
+	_, err = ei.Process(parseNode(t, "a: !IncludeText foo.txt"))
+	var sandboxErr *SandboxError
+	require.True(t, errors.As(err, &sandboxErr))
+	assert.Equal(t, "tag !IncludeText is not allowed", sandboxErr.Message)
+
+	var emrichenErr *Error
+	require.True(t, errors.As(err, &emrichenErr))
+	assert.Equal(t, 1, emrichenErr.Line)
+	assert.Equal(t, 4, emrichenErr.Column)
+
+	ei, err = NewInterpreter(WithSandbox(Sandbox{AllowedTags: []string{"!Defaults", "!Var", "!Format"}}))
+	require.NoError(t, err)
+
+	output := processToYAML(t, ei, "!Defaults {a: 1}\n---\n[!Var a, !Format \"{a}\"]")
+	assert.Equal(t, "- 1\n- \"1\"\n", output)
+
+	_, err = ei.Process(parseNode(t, "!Concat [[1], [2]]"))
+	assert.True(t, errors.Is(err, ErrSandboxViolation))
+}
+
+func TestSandboxFuncs(t *testing.T) {
+	funcmap := template.FuncMap{
+		"env":   os.Getenv,
+		"upper": func(s string) string { return s + "!" },
+	}
+
+	ei, err := NewInterpreter(WithFuncMap(funcmap), WithSandbox(DefaultSandbox("")))
+	require.NoError(t, err)
+
+	output := processToYAML(t, ei, `!Format "{{ upper \"hi\" }}"`)
+	assert.Equal(t, "hi!\n", output)
+
+	_, err = ei.Process(parseNode(t, `!Format "{{ env \"HOME\" }}"`))
+	assert.True(t, errors.Is(err, ErrSandboxViolation))
+
+	ei, err = NewInterpreter(WithFuncMap(funcmap), WithSandbox(Sandbox{AllowedFuncs: []string{"env"}}))
+	require.NoError(t, err)
+
+	_, err = ei.Process(parseNode(t, `!Format "{{ upper \"hi\" }}"`))
+	assert.True(t, errors.Is(err, ErrSandboxViolation))
+}
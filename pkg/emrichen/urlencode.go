@@ -33,8 +33,8 @@ func (ei *Interpreter) handleURLEncode(node *yaml.Node) (*yaml.Node, error) {
 		return makeString(parsedURL.String()), nil
 
 	case yaml.DocumentNode, yaml.SequenceNode, yaml.AliasNode:
-		return nil, errors.New("!URLEncode requires a scalar or mapping node")
+		return nil, tagArgumentErrorf("!URLEncode requires a scalar or mapping node")
 	}
 
-	return nil, errors.New("!URLEncode requires a scalar or mapping node")
+	return nil, tagArgumentErrorf("!URLEncode requires a scalar or mapping node")
 }
@@ -7,9 +7,11 @@ import (
 	"net"
 	"net/url"
 	"reflect"
+	"sort"
 	"strconv"
 	"time"
 
+	"github.com/go-go-golems/go-emrichen/pkg/env"
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 
@@ -136,7 +138,9 @@ func NodeToSlice(node *yaml.Node) ([]interface{}, bool) {
 	return slice, true
 }
 
-func NodeToMap(node *yaml.Node) (map[string]interface{}, bool) {
+// NodeToMap parses a YAML mapping node into an ordered map, keeping the order
+// of its keys.
+func NodeToMap(node *yaml.Node) (*env.OrderedMap, bool) {
 	if node == nil {
 		return nil, false
 	}
@@ -144,7 +148,7 @@ func NodeToMap(node *yaml.Node) (map[string]interface{}, bool) {
 		return nil, false // Invalid map node
 	}
 
-	m := make(map[string]interface{})
+	m := env.NewOrderedMap()
 	for i := 0; i < len(node.Content); i += 2 {
 		keyNode := node.Content[i]
 		valueNode := node.Content[i+1]
@@ -155,7 +159,7 @@ func NodeToMap(node *yaml.Node) (map[string]interface{}, bool) {
 
 		key := keyNode.Value
 		if val, ok := NodeToInterface(valueNode); ok {
-			m[key] = val
+			m.Set(key, val)
 		} else {
 			return nil, false
 		}
@@ -168,6 +172,35 @@ func ValueToNode(value interface{}) (*yaml.Node, error) {
 		return makeNil(), nil
 	}
 
+	// Nodes stored as variables are returned unchanged, aliases being
+	// replaced by the node they refer to, without its anchor like a
+	// processed alias
+	if node, ok := value.(*yaml.Node); ok {
+		if node != nil && node.Kind == yaml.AliasNode {
+			node = resolveAlias(node)
+			if node != nil && node.Anchor != "" {
+				node_ := *node
+				node_.Anchor = ""
+				node = &node_
+			}
+		}
+		if node == nil {
+			return makeNil(), nil
+		}
+		return node, nil
+	}
+
+	// Handle ordered maps before fmt.Stringer, keeping their order
+	if m, ok := value.(*env.OrderedMap); ok {
+		if m == nil {
+			return makeNil(), nil
+		}
+		return OrderedMapToNode(m)
+	}
+	if m, ok := value.(env.OrderedMap); ok {
+		return OrderedMapToNode(&m)
+	}
+
 	// Handle specific types before reflection
 
 	// Handle time.Time
@@ -273,7 +306,12 @@ func GenericMapToNode(mapValue reflect.Value) (*yaml.Node, error) {
 		Kind: yaml.MappingNode,
 		Tag:  "!!map",
 	}
-	for _, key := range mapValue.MapKeys() {
+	// Go maps are unordered, sort the keys to get a deterministic output
+	keys := mapValue.MapKeys()
+	sort.SliceStable(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+	})
+	for _, key := range keys {
 		keyNode, err := ValueToNode(key.Interface())
 		if err != nil {
 			return nil, err
@@ -287,6 +325,24 @@ func GenericMapToNode(mapValue reflect.Value) (*yaml.Node, error) {
 	return node, nil
 }
 
+// OrderedMapToNode converts an ordered map to a mapping node, keeping the
+// order of its keys.
+func OrderedMapToNode(m *env.OrderedMap) (*yaml.Node, error) {
+	node := &yaml.Node{
+		Kind: yaml.MappingNode,
+		Tag:  "!!map",
+	}
+	for _, key := range m.Keys() {
+		value, _ := m.Get(key)
+		valueNode, err := ValueToNode(value)
+		if err != nil {
+			return nil, err
+		}
+		node.Content = append(node.Content, makeString(key), valueNode)
+	}
+	return node, nil
+}
+
 // makeString converts a string value to a corresponding scalar YAML node.
 func makeString(value string) *yaml.Node {
 	return &yaml.Node{
@@ -78,7 +78,13 @@ func runTests(t *testing.T, tests []testCase) {
 				if tc.expectError {
 					require.Error(t, err, "Expected an error but got none")
 					if tc.expectErrorMessage != "" {
-						assert.Equal(t, tc.expectErrorMessage, err.Error())
+						// compare the message without the source position prefix
+						msg := err.Error()
+						var emrichenErr *Error
+						if errors.As(err, &emrichenErr) {
+							msg = emrichenErr.Err.Error()
+						}
+						assert.Equal(t, tc.expectErrorMessage, msg)
 					}
 				} else {
 					require.NoError(t, err, "Unexpected error encountered", err)
@@ -1,16 +1,19 @@
 package emrichen
 
 import (
-	"github.com/pkg/errors"
+	"github.com/go-go-golems/go-emrichen/pkg/env"
 	"gopkg.in/yaml.v3"
 )
 
 func (ei *Interpreter) handleVar(node *yaml.Node) (*yaml.Node, error) {
 	if node.Kind == yaml.ScalarNode {
 		varName := node.Value
-		varValue, ok := ei.env.GetVar(varName)
+		varValue, ok, err := ei.env.ResolveVar(varName)
+		if err != nil {
+			return nil, err
+		}
 		if !ok {
-			return nil, errors.Errorf("variable %s not found", varName)
+			return nil, &env.VariableNotFoundError{Name: varName}
 		}
 		v, err := ValueToNode(varValue)
 		if err != nil {
@@ -18,5 +21,5 @@ func (ei *Interpreter) handleVar(node *yaml.Node) (*yaml.Node, error) {
 		}
 		return v, nil
 	}
-	return nil, errors.New("variable definition must be !Var variable name")
+	return nil, tagArgumentErrorf("variable definition must be !Var variable name")
 }
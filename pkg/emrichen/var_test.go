@@ -1,6 +1,12 @@
 package emrichen
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
 
 func TestEmrichenVarTag(t *testing.T) {
 	tests := []testCase{
@@ -87,3 +93,150 @@ func TestEmrichenVarTag(t *testing.T) {
 
 	runTests(t, tests)
 }
+
+func TestEmrichenVarFromEnviron(t *testing.T) {
+	environ := []string{"HOME=/home/user", "EMPTY=", "WITH_EQUALS=a=b"}
+
+	tests := []struct {
+		name      string
+		namespace string
+		inputYAML string
+		expected  string
+	}{
+		{
+			name:      "Lookup in namespace",
+			namespace: "env",
+			inputYAML: "!Lookup env.HOME",
+			expected:  "/home/user",
+		},
+		{
+			name:      "Var at top level",
+			namespace: "",
+			inputYAML: "!Var HOME",
+			expected:  "/home/user",
+		},
+		{
+			name:      "Value containing equals sign",
+			namespace: "env",
+			inputYAML: "!Lookup env.WITH_EQUALS",
+			expected:  "a=b",
+		},
+		{
+			name:      "Empty value",
+			namespace: "env",
+			inputYAML: "!Lookup env.EMPTY",
+			expected:  "",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ei, err := NewInterpreter(WithEnviron(tc.namespace, environ))
+			require.NoError(t, err)
+
+			var result string
+			err = yaml.Unmarshal([]byte(tc.inputYAML), ei.CreateDecoder(&result))
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, result)
+		})
+	}
+}
+
+func TestVarPreservesNodes(t *testing.T) {
+	defaults := `!Defaults
+custom: !Custom value
+date: 2024-01-02
+binary: !!binary aGVsbG8=
+big: 18446744073709551615
+hex: 0x1F
+mapping:
+  z: 1
+  a: 2
+  3: int key
+items:
+  - value: !Custom value
+  - value: 2024-01-02
+---
+`
+
+	tests := []struct {
+		name      string
+		inputYAML string
+		expected  string
+	}{
+		{
+			name:      "Custom tag",
+			inputYAML: "a: !Var custom",
+			expected:  "a: !Custom value\n",
+		},
+		{
+			name:      "Timestamp",
+			inputYAML: "a: !Var date",
+			expected:  "a: 2024-01-02\n",
+		},
+		{
+			name:      "Binary",
+			inputYAML: "a: !Var binary",
+			expected:  "a: !!binary aGVsbG8=\n",
+		},
+		{
+			name:      "Integer larger than int",
+			inputYAML: "a: !Var big",
+			expected:  "a: 18446744073709551615\n",
+		},
+		{
+			name:      "Key order and non-string keys",
+			inputYAML: "a: !Var mapping",
+			expected:  "a:\n    z: 1\n    a: 2\n    3: int key\n",
+		},
+		{
+			name:      "Lookup returns nodes unchanged",
+			inputYAML: "a: !Lookup mapping\nb: !Lookup custom\nc: !Lookup hex",
+			expected:  "a:\n    z: 1\n    a: 2\n    3: int key\nb: !Custom value\nc: 0x1F\n",
+		},
+		{
+			name:      "Lookup into a mapping",
+			inputYAML: "a: !Lookup mapping.z\nb: !LookupAll items[*].value",
+			expected:  "a: 1\nb:\n    - !Custom value\n    - 2024-01-02\n",
+		},
+		{
+			name:      "Loop items",
+			inputYAML: "a: !Loop\n  over: [!Var custom, !Var date]\n  template: !Var item",
+			expected:  "a:\n    - !Custom value\n    - 2024-01-02\n",
+		},
+		{
+			name:      "Loop over mapping keys",
+			inputYAML: "a: !Loop\n  over: !Var mapping\n  index_as: key\n  template: !Var key",
+			expected:  "a:\n    z: z\n    a: a\n    3: 3\n",
+		},
+		{
+			name:      "Template boundary",
+			inputYAML: "a: !Format \"{custom} {{ .date.Year }} {binary} {big}\"",
+			expected:  "a: value 2024 hello 18446744073709551615\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ei, err := NewInterpreter()
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, processToYAML(t, ei, defaults+tc.inputYAML))
+		})
+	}
+}
+
+func TestVarResolvesAliases(t *testing.T) {
+	vars := parseNode(t, "base: &base {x: 1}\nlist: &list [a, b]\nref: *base\nrefList: *list\n").Content[0]
+	ei, err := NewInterpreter(WithVars(map[string]interface{}{
+		"ref":     vars.Content[5],
+		"refList": vars.Content[7],
+	}))
+	require.NoError(t, err)
+	require.Equal(t, yaml.AliasNode, vars.Content[5].Kind)
+
+	output := processToYAML(t, ei, `a: !Var ref
+b: !Lookup ref.x
+c: !Loop {over: !Var refList, template: !Format "{item}!"}
+d: !Merge [!Var ref, {y: 2}]`)
+	assert.Equal(t, "a: {x: 1}\nb: 1\nc:\n    - a!\n    - b!\nd:\n    x: 1\n    y: 2\n", output)
+}
@@ -1,22 +1,21 @@
 package emrichen
 
 import (
-	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
 )
 
 func (ei *Interpreter) handleWith(node *yaml.Node) (*yaml.Node, error) {
 	if node.Kind != yaml.MappingNode {
-		return nil, errors.New("!With requires a mapping node")
+		return nil, tagArgumentErrorf("!With requires a mapping node")
 	}
 
 	varsNode, templateNode := findWithNodes(node.Content)
 	if varsNode == nil || templateNode == nil {
-		return nil, errors.New("!With requires 'vars' and 'template' nodes")
+		return nil, tagArgumentErrorf("!With requires 'vars' and 'template' nodes")
 	}
 
 	if varsNode.Kind != yaml.MappingNode {
-		return nil, errors.New("!With 'vars' node must be a mapping node")
+		return nil, tagArgumentErrorf("!With 'vars' node must be a mapping node")
 	}
 
 	if err := ei.updateVars(varsNode.Content); err != nil {
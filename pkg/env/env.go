@@ -3,41 +3,88 @@
 package env
 
 import (
-	"github.com/pkg/errors"
+	"strings"
+	"sync"
+
 	"k8s.io/client-go/util/jsonpath"
 )
 
-// Frame represents a single variable frame containing a map of variables.
+// Frame is a single variable frame, holding the variables it defines and a
+// link to its parent frame. Variables of the parent are visible unless they
+// are shadowed. Frames are immutable once created, so that pushing a frame
+// doesn't copy the variables of its parents, and frames can be shared by
+// several environments.
 type Frame struct {
-	Variables map[string]interface{}
+	parent *Frame
+	vars   map[string]interface{}
+
+	// variables is the lazily computed union of the variables of the frame
+	// and its parents, see Variables.
+	once      sync.Once
+	variables map[string]interface{}
 }
 
-// NewFrame creates a new Frame with variables. It takes an optional parent frame
-// and merges its variables (shallow copy) with newVars. If parent is nil, only newVars
-// are used. It's primarily used internally by Env.
+// NewFrame creates a new Frame defining newVars on top of an optional parent
+// frame. newVars is copied, the variables of parent are not. It's primarily
+// used internally by Env.
 func NewFrame(parent *Frame, newVars map[string]interface{}) *Frame {
-	mergedVars := make(map[string]interface{})
-	if parent != nil {
-		// Shallow copy of the parent's variables
-		for k, v := range parent.Variables {
-			mergedVars[k] = v
-		}
+	vars := make(map[string]interface{}, len(newVars))
+	for k, v := range newVars {
+		vars[k] = v
 	}
+	return &Frame{parent: parent, vars: vars}
+}
 
-	// Add new variables
-	for k, v := range newVars {
-		mergedVars[k] = v
+// Get returns the value of a variable, looking it up in the frame and then in
+// its parents.
+func (f *Frame) Get(name string) (interface{}, bool) {
+	for ; f != nil; f = f.parent {
+		if v, ok := f.vars[name]; ok {
+			return v, true
+		}
 	}
+	return nil, false
+}
 
-	return &Frame{Variables: mergedVars}
+// Variables returns all the variables visible in the frame, with variables of
+// the frame shadowing those of its parents. The map is computed on the first
+// call and must not be modified.
+func (f *Frame) Variables() map[string]interface{} {
+	f.once.Do(func() {
+		if f.parent == nil {
+			f.variables = f.vars
+			return
+		}
+		if len(f.vars) == 0 {
+			f.variables = f.parent.Variables()
+			return
+		}
+		parentVars := f.parent.Variables()
+		f.variables = make(map[string]interface{}, len(parentVars)+len(f.vars))
+		for k, v := range parentVars {
+			f.variables[k] = v
+		}
+		for k, v := range f.vars {
+			f.variables[k] = v
+		}
+	})
+	return f.variables
 }
 
 // Env represents an environment with a stack of variable frames.
 // It allows pushing and popping frames and querying variables.
 type Env struct {
 	stack []*Frame
+	// resolve, if set, computes the values of lazily evaluated variables,
+	// see WithResolver.
+	resolve Resolver
 }
 
+// Resolver computes the value of a variable stored as a placeholder, for
+// variables that are evaluated lazily. It returns values that are not
+// placeholders unchanged.
+type Resolver func(v interface{}) (interface{}, error)
+
 type EnvOption func(*Env)
 
 // NewEnv creates a new environment for managing variable frames.
@@ -57,20 +104,41 @@ func NewEnv(options ...EnvOption) *Env {
 func WithVars(vars map[string]interface{}) EnvOption {
 	return func(e *Env) {
 		if len(e.stack) == 0 {
-			e.stack = append(e.stack, &Frame{Variables: vars})
+			e.stack = append(e.stack, NewFrame(nil, vars))
 			return
 		}
+		// frames are immutable, replace the current frame by one defining
+		// both its variables and vars
 		currentFrame := e.GetCurrentFrame()
+		merged := make(map[string]interface{}, len(currentFrame.vars)+len(vars))
+		for k, v := range currentFrame.vars {
+			merged[k] = v
+		}
 		for k, v := range vars {
-			currentFrame.Variables[k] = v
+			merged[k] = v
 		}
+		e.stack[len(e.stack)-1] = NewFrame(currentFrame.parent, merged)
+	}
+}
+
+// WithResolver is an option for NewEnv setting the Resolver applied to the
+// variables returned by ResolveVar and seen by jsonpath lookups.
+func WithResolver(resolve Resolver) EnvOption {
+	return func(e *Env) {
+		e.resolve = resolve
 	}
 }
 
-// Push creates a new frame on top of the stack with newVars.
-// It performs a shallow copy of the variables from the current top frame
-// and merges them with newVars. If the stack is empty, newVars becomes
-// the first frame.
+// WithFrame is an option for NewEnv pushing an existing frame, whose
+// variables are shared rather than copied.
+func WithFrame(frame *Frame) EnvOption {
+	return func(e *Env) {
+		e.stack = append(e.stack, frame)
+	}
+}
+
+// Push creates a new frame on top of the stack with newVars, whose parent is
+// the current top frame. The variables of the parent frames are not copied.
 func (e *Env) Push(newVars map[string]interface{}) {
 	var parent *Frame
 	if len(e.stack) > 0 {
@@ -106,41 +174,102 @@ func (e *Env) GetCurrentFrame() *Frame {
 
 // GetVar tries to retrieve a variable's value by its name from the current frame.
 // Returns the value and a boolean indicating if the variable was found.
-// If the stack is empty, it returns nil and false.
+// If the stack is empty, it returns nil and false. The value is returned as
+// stored, without applying the Resolver, see ResolveVar.
 func (e *Env) GetVar(name string) (interface{}, bool) {
-	v := e.GetCurrentFrame()
-	if v == nil {
-		return nil, false
+	return e.GetCurrentFrame().Get(name)
+}
+
+// ResolveVar is like GetVar, but computes the value of lazily evaluated
+// variables with the Resolver of the environment. Errors returned by the
+// Resolver are returned as is.
+func (e *Env) ResolveVar(name string) (interface{}, bool, error) {
+	v, ok := e.GetVar(name)
+	if !ok {
+		return nil, false, nil
+	}
+	v, err := e.resolveValue(v)
+	if err != nil {
+		return nil, false, err
 	}
-	val, ok := v.Variables[name]
-	return val, ok
+	return v, true, nil
+}
+
+// SetResolver replaces the Resolver of the environment.
+func (e *Env) SetResolver(resolve Resolver) {
+	e.resolve = resolve
+}
+
+func (e *Env) resolveValue(v interface{}) (interface{}, error) {
+	if e.resolve == nil {
+		return v, nil
+	}
+	return e.resolve(v)
+}
+
+// Fork returns a new environment whose only frame is the current frame of e,
+// and which uses the same Resolver.
+// Since frames are immutable, both environments can then be used
+// independently, including from different goroutines.
+func (e *Env) Fork() *Env {
+	ret := NewEnv(WithResolver(e.resolve))
+	if frame := e.GetCurrentFrame(); frame != nil {
+		ret.stack = append(ret.stack, frame)
+	}
+	return ret
 }
 
 // LookupAll performs a jsonpath query on the variables of the current frame.
 // It returns all matches as a slice of interface{} and an error if the query
 // fails or if the current frame is nil. The function requires a valid jsonpath
 // expression and uses the Kubernetes jsonpath package.
+//
+// Matches within *yaml.Node and *OrderedMap variables are returned as the
+// values they correspond to, except for scalars that can be represented
+// exactly as plain Go values.
+//
+// Lazily evaluated variables are resolved before the query, errors of the
+// Resolver are returned as is. Failures are reported as *PathError, matching ErrInvalidPath if the expression
+// could not be parsed and ErrPathNotFound if it could not be evaluated.
 func (e *Env) LookupAll(expression string, allowMissingKeys bool) ([]interface{}, error) {
 	v := e.GetCurrentFrame()
 	if v == nil {
 		return nil, nil
 	}
 
+	// a plain variable reference returns the variable unchanged
+	if name, ok := rootVariable(expression); ok && name == expression[2:] {
+		if value, ok := v.Get(name); ok {
+			value, err := e.resolveValue(value)
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{value}, nil
+		}
+	}
+
 	j := jsonpath.New("jsonpath")
 	err := j.Parse("{" + expression + "}")
 	if err != nil {
-		return nil, err
+		return nil, &PathError{Expression: expression, Reason: ErrInvalidPath, Err: err}
 	}
 
-	results, err := j.AllowMissingKeys(allowMissingKeys).FindResults(v.Variables)
+	// jsonpath only fails at evaluation time when the expression does not
+	// match the shape of the data (missing keys, out of bounds indices, ...)
+	index := valueIndex{}
+	data, err := e.lookupData(v, expression, index)
 	if err != nil {
 		return nil, err
 	}
+	results, err := j.AllowMissingKeys(allowMissingKeys).FindResults(data)
+	if err != nil {
+		return nil, &PathError{Expression: expression, Reason: ErrPathNotFound, Err: err}
+	}
 
 	var finalResults []interface{}
 	for _, result := range results {
 		for _, r := range result {
-			finalResults = append(finalResults, r.Interface())
+			finalResults = append(finalResults, resultValue(r, index))
 		}
 	}
 
@@ -150,6 +279,7 @@ func (e *Env) LookupAll(expression string, allowMissingKeys bool) ([]interface{}
 // LookupFirst performs a jsonpath query on the variables of the current frame.
 // It returns the first match as an interface{} and an error if the query
 // fails or if the current frame is nil, or if no matching node is found.
+// A missing match is reported as a *PathError matching ErrPathNotFound.
 func (e *Env) LookupFirst(expression string) (interface{}, error) {
 	res, err := e.LookupAll(expression, false)
 	if err != nil {
@@ -157,8 +287,55 @@ func (e *Env) LookupFirst(expression string) (interface{}, error) {
 	}
 
 	if len(res) == 0 {
-		return nil, errors.Errorf("no matching node found for expression %q", expression)
+		return nil, &PathError{Expression: expression, Reason: ErrPathNotFound}
 	}
 
 	return res[0], nil
 }
+
+// lookupData returns the variables a jsonpath expression is evaluated against,
+// converting *yaml.Node and *OrderedMap values. Expressions only referring to the root
+// once, such as `$.a.b[0]`, only need their first key, which avoids
+// materializing (and resolving) all the variables of frame.
+func (e *Env) lookupData(frame *Frame, expression string, index valueIndex) (map[string]interface{}, error) {
+	name, ok := rootVariable(expression)
+	if !ok {
+		vars := frame.Variables()
+		ret := make(map[string]interface{}, len(vars))
+		for k, v := range vars {
+			v, err := e.resolveValue(v)
+			if err != nil {
+				return nil, err
+			}
+			ret[k] = lookupValue(v, index)
+		}
+		return ret, nil
+	}
+
+	v, ok := frame.Get(name)
+	if !ok {
+		return map[string]interface{}{}, nil
+	}
+	v, err := e.resolveValue(v)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{name: lookupValue(v, index)}, nil
+}
+
+// rootVariable returns the name of the variable a jsonpath expression starts
+// with, if it doesn't refer to the root anywhere else.
+func rootVariable(expression string) (string, bool) {
+	rest, ok := strings.CutPrefix(expression, "$.")
+	if !ok || strings.Contains(rest, "$") {
+		return "", false
+	}
+	name := rest
+	if i := strings.IndexAny(rest, ".["); i >= 0 {
+		name = rest[:i]
+	}
+	if name == "" || strings.ContainsAny(name, "*?@()'\" ,{}") {
+		return "", false
+	}
+	return name, true
+}
@@ -0,0 +1,61 @@
+package env
+
+import (
+	"fmt"
+	"testing"
+)
+
+func benchmarkVars(n int) map[string]interface{} {
+	vars := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		vars[fmt.Sprintf("var%d", i)] = i
+	}
+	return vars
+}
+
+// BenchmarkPushPop simulates a loop pushing a frame per item on top of a
+// large set of variables.
+func BenchmarkPushPop(b *testing.B) {
+	for _, n := range []int{10, 1000, 10000} {
+		b.Run(fmt.Sprintf("vars=%d", n), func(b *testing.B) {
+			env := NewEnv()
+			env.Push(benchmarkVars(n))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				env.Push(map[string]interface{}{"item": i, "index": i})
+				if _, ok := env.GetVar("item"); !ok {
+					b.Fatal("item not found")
+				}
+				env.Pop()
+			}
+		})
+	}
+}
+
+// BenchmarkGetVarNested looks up a variable defined several frames below the
+// current one.
+func BenchmarkGetVarNested(b *testing.B) {
+	env := NewEnv()
+	env.Push(benchmarkVars(1000))
+	for i := 0; i < 5; i++ {
+		env.Push(map[string]interface{}{fmt.Sprintf("nested%d", i): i})
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ok := env.GetVar("var500"); !ok {
+			b.Fatal("var500 not found")
+		}
+	}
+}
+
+func BenchmarkLookupFirst(b *testing.B) {
+	env := NewEnv()
+	env.Push(benchmarkVars(1000))
+	env.Push(map[string]interface{}{"item": map[string]interface{}{"name": "foo"}})
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := env.LookupFirst("$.item.name"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
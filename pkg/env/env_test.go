@@ -1,9 +1,13 @@
 package env
 
 import (
+	"errors"
+
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
 	"testing"
+	"time"
 )
 
 func TestEnvFrameWithVars(t *testing.T) {
@@ -50,17 +54,17 @@ func TestEnvFrameWithVars(t *testing.T) {
 			// Setup parent frame if needed
 			var parent *Frame
 			if tc.parentVars != nil {
-				parent = &Frame{Variables: tc.parentVars}
+				parent = NewFrame(nil, tc.parentVars)
 			}
 
 			// Create new frame with newVars and parent
 			frame := NewFrame(parent, tc.newVars)
 
 			// Verify the frame's variables
-			assert.Equal(t, tc.expectedVars, frame.Variables)
+			assert.Equal(t, tc.expectedVars, frame.Variables())
 
 			// Create new environment with options
-			env := NewEnv(WithVars(frame.Variables))
+			env := NewEnv(WithVars(frame.Variables()))
 
 			// Verify the number of frames in the environment
 			assert.Len(t, env.stack, tc.expectedFrames)
@@ -68,7 +72,7 @@ func TestEnvFrameWithVars(t *testing.T) {
 			if tc.expectedFrames > 0 {
 				// Verify the variables in the current top frame
 				currentFrame := env.GetCurrentFrame()
-				assert.Equal(t, tc.expectedVars, currentFrame.Variables)
+				assert.Equal(t, tc.expectedVars, currentFrame.Variables())
 			}
 		})
 	}
@@ -145,7 +149,7 @@ func TestEnvPushPopGetCurrentFrame(t *testing.T) {
 			// Verify the variables in the current top frame
 			currentFrame := env.GetCurrentFrame()
 			if currentFrame != nil {
-				assert.Equal(t, tc.expectedTopVars, currentFrame.Variables)
+				assert.Equal(t, tc.expectedTopVars, currentFrame.Variables())
 			} else {
 				assert.Nil(t, tc.expectedTopVars)
 			}
@@ -492,3 +496,198 @@ func TestEnvStackOperations(t *testing.T) {
 		})
 	}
 }
+
+func TestEnvLookupErrors(t *testing.T) {
+	env := NewEnv(WithVars(map[string]interface{}{
+		"foo":  map[string]interface{}{"bar": "baz"},
+		"list": []interface{}{1, 2},
+	}))
+
+	tests := []struct {
+		name           string
+		expression     string
+		expectedReason error
+	}{
+		{name: "Missing key", expression: "$.foo.missing", expectedReason: ErrPathNotFound},
+		{name: "Missing top-level variable", expression: "$.missing", expectedReason: ErrPathNotFound},
+		{name: "Index out of bounds", expression: "$.list[5]", expectedReason: ErrPathNotFound},
+		{name: "Malformed expression", expression: "$.foo[", expectedReason: ErrInvalidPath},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := env.LookupFirst(tc.expression)
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, tc.expectedReason), "unexpected error: %v", err)
+
+			var pathErr *PathError
+			require.True(t, errors.As(err, &pathErr))
+			assert.Equal(t, tc.expression, pathErr.Expression)
+		})
+	}
+}
+
+func TestVariableNotFoundError(t *testing.T) {
+	err := error(&VariableNotFoundError{Name: "foo"})
+	assert.True(t, errors.Is(err, ErrVariableNotFound))
+	assert.False(t, errors.Is(err, ErrPathNotFound))
+	assert.Equal(t, "variable foo not found", err.Error())
+}
+
+func TestFrameChaining(t *testing.T) {
+	base := map[string]interface{}{"a": 1, "b": 2}
+	env := NewEnv()
+	env.Push(base)
+	env.Push(map[string]interface{}{"b": 3})
+
+	// pushed maps are copied, modifying them doesn't change the frames
+	base["a"] = 10
+	v, ok := env.GetVar("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+
+	// variables of parent frames are shadowed, not overwritten
+	v, _ = env.GetVar("b")
+	assert.Equal(t, 3, v)
+	assert.Equal(t, map[string]interface{}{"a": 1, "b": 3}, env.GetCurrentFrame().Variables())
+	env.Pop()
+	v, _ = env.GetVar("b")
+	assert.Equal(t, 2, v)
+	assert.Equal(t, map[string]interface{}{"a": 1, "b": 2}, env.GetCurrentFrame().Variables())
+}
+
+func TestEnvFork(t *testing.T) {
+	env := NewEnv(WithVars(map[string]interface{}{"a": 1}))
+	env.Push(map[string]interface{}{"b": 2})
+
+	fork := env.Fork()
+	fork.Push(map[string]interface{}{"c": 3})
+	env.Pop()
+
+	_, ok := env.GetVar("b")
+	assert.False(t, ok)
+	_, ok = env.GetVar("c")
+	assert.False(t, ok)
+	assert.Equal(t, map[string]interface{}{"a": 1, "b": 2, "c": 3}, fork.GetCurrentFrame().Variables())
+
+	fork.Pop()
+	fork.Pop()
+	assert.Nil(t, fork.GetCurrentFrame())
+}
+
+func TestEnvLookupRootVariable(t *testing.T) {
+	env := NewEnv(WithVars(map[string]interface{}{
+		"limit": 2,
+		"items": []interface{}{
+			map[string]interface{}{"name": "a", "size": 1},
+			map[string]interface{}{"name": "b", "size": 3},
+		},
+	}))
+	env.Push(map[string]interface{}{"item": map[string]interface{}{"name": "c"}})
+
+	tests := []struct {
+		expression string
+		expected   []interface{}
+	}{
+		{expression: "$.item.name", expected: []interface{}{"c"}},
+		{expression: "$.items[1].name", expected: []interface{}{"b"}},
+		{expression: "$.items[*].name", expected: []interface{}{"a", "b"}},
+		{expression: "$.items[?(@.size > 2)].name", expected: []interface{}{"b"}},
+		{expression: "$.*.name", expected: []interface{}{"c"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.expression, func(t *testing.T) {
+			results, err := env.LookupAll(tc.expression, false)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, tc.expected, results)
+		})
+	}
+}
+
+func TestEnvNodeValues(t *testing.T) {
+	doc := &yaml.Node{}
+	require.NoError(t, yaml.Unmarshal([]byte(`
+date: 2024-01-02
+tagged: !Custom value
+list: [1, {name: a}]
+`), doc))
+	root := doc.Content[0]
+
+	env := NewEnv(WithVars(map[string]interface{}{"root": root}))
+
+	v, ok := env.GetVar("root")
+	require.True(t, ok)
+	assert.Same(t, root, v)
+
+	// mappings, sequences and scalars without a plain value are returned as nodes
+	v, err := env.LookupFirst("$.root.list")
+	require.NoError(t, err)
+	assert.Same(t, root.Content[5], v)
+	v, err = env.LookupFirst("$.root.tagged")
+	require.NoError(t, err)
+	assert.Same(t, root.Content[3], v)
+
+	v, err = env.LookupFirst("$.root.list[1].name")
+	require.NoError(t, err)
+	assert.Equal(t, "a", v)
+	v, err = env.LookupFirst("$.root.list[0]")
+	require.NoError(t, err)
+	assert.Equal(t, 1, v)
+
+	assert.Equal(t, map[string]interface{}{
+		"date":   time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+		"tagged": "value",
+		"list":   []interface{}{1, map[string]interface{}{"name": "a"}},
+	}, NodeToValue(root))
+	assert.Equal(t, "plain", NodeToValue("plain"))
+}
+
+type lazyValue struct {
+	value interface{}
+	err   error
+}
+
+func TestEnvResolver(t *testing.T) {
+	resolve := func(v interface{}) (interface{}, error) {
+		if l, ok := v.(lazyValue); ok {
+			return l.value, l.err
+		}
+		return v, nil
+	}
+	env := NewEnv(WithResolver(resolve), WithVars(map[string]interface{}{
+		"plain":  1,
+		"lazy":   lazyValue{value: map[string]interface{}{"name": "a"}},
+		"broken": lazyValue{err: errors.New("broken")},
+	}))
+
+	// GetVar returns the stored value
+	v, ok := env.GetVar("lazy")
+	assert.True(t, ok)
+	assert.IsType(t, lazyValue{}, v)
+
+	v, ok, err := env.ResolveVar("lazy")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, map[string]interface{}{"name": "a"}, v)
+	v, ok, err = env.ResolveVar("plain")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+	_, ok, err = env.ResolveVar("missing")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	_, _, err = env.ResolveVar("broken")
+	assert.EqualError(t, err, "broken")
+
+	v, err = env.LookupFirst("$.lazy.name")
+	require.NoError(t, err)
+	assert.Equal(t, "a", v)
+	_, err = env.LookupFirst("$.broken")
+	assert.EqualError(t, err, "broken")
+
+	// forks keep the resolver
+	v, err = env.Fork().LookupFirst("$.lazy.name")
+	require.NoError(t, err)
+	assert.Equal(t, "a", v)
+}
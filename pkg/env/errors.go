@@ -0,0 +1,58 @@
+package env
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrVariableNotFound is matched (using errors.Is) by errors reporting
+	// an undefined variable.
+	ErrVariableNotFound = errors.New("variable not found")
+	// ErrPathNotFound is matched by errors reporting a jsonpath expression
+	// that did not resolve against the current variables.
+	ErrPathNotFound = errors.New("path not found")
+	// ErrInvalidPath is matched by errors reporting a malformed jsonpath expression.
+	ErrInvalidPath = errors.New("invalid path")
+)
+
+// VariableNotFoundError is returned when looking up a variable that is not defined.
+type VariableNotFoundError struct {
+	Name string
+}
+
+func (e *VariableNotFoundError) Error() string {
+	return fmt.Sprintf("variable %s not found", e.Name)
+}
+
+func (e *VariableNotFoundError) Is(target error) bool {
+	return target == ErrVariableNotFound
+}
+
+// PathError is returned when a jsonpath lookup fails. Reason is either
+// ErrPathNotFound or ErrInvalidPath, and can be tested with errors.Is.
+type PathError struct {
+	Expression string
+	Reason     error
+	// Err is the error returned by the jsonpath library, if any.
+	Err error
+}
+
+func (e *PathError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	if e.Reason == ErrInvalidPath {
+		return fmt.Sprintf("invalid expression %q", e.Expression)
+	}
+	return fmt.Sprintf("no matching node found for expression %q", e.Expression)
+}
+
+func (e *PathError) Is(target error) bool {
+	return target == e.Reason
+}
+
+func (e *PathError) Unwrap() error {
+	return e.Err
+}
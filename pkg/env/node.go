@@ -0,0 +1,177 @@
+package env
+
+import (
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Variables can hold *yaml.Node and *OrderedMap values, which are returned
+// unchanged by GetVar, preserving their tags, key order and exact scalar
+// values. They are converted to plain Go values for jsonpath lookups and by
+// NodeToValue.
+
+// NodeToValue converts YAML nodes and ordered maps to plain Go values:
+// mappings become map[string]interface{}, sequences []interface{}, and
+// scalars are decoded like yaml.Unmarshal into an interface{} (so !!timestamp
+// scalars become time.Time). Plain maps and slices are copied if they contain
+// values to convert, other values are returned as is.
+func NodeToValue(v interface{}) interface{} {
+	ret, _ := convertValue(v, nil)
+	return ret
+}
+
+// valueIndex maps the maps and slices created when converting values for a
+// lookup back to the values they were created from.
+type valueIndex map[uintptr]interface{}
+
+func (index valueIndex) record(converted interface{}, original interface{}) {
+	if index == nil {
+		return
+	}
+	rv := reflect.ValueOf(converted)
+	// empty slices don't have an identity
+	if rv.Kind() == reflect.Slice && rv.Len() == 0 {
+		return
+	}
+	index[rv.Pointer()] = original
+}
+
+// lookupValue converts v for a jsonpath lookup. Unlike NodeToValue, scalars
+// which can't be represented exactly as plain values (custom tags,
+// timestamps, binary data, ...) are kept as nodes, and the converted mappings
+// and sequences are recorded in index.
+func lookupValue(v interface{}, index valueIndex) interface{} {
+	ret, _ := convertValue(v, index)
+	return ret
+}
+
+// convertValue converts the nodes and ordered maps in v, and returns whether
+// anything was converted.
+func convertValue(v interface{}, index valueIndex) (interface{}, bool) {
+	switch v_ := v.(type) {
+	case *yaml.Node:
+		return nodeToValue(v_, index), true
+	case *OrderedMap:
+		if v_ == nil {
+			return nil, true
+		}
+		m := make(map[string]interface{}, len(v_.keys))
+		for k, value := range v_.values {
+			m[k], _ = convertValue(value, index)
+		}
+		index.record(m, v_)
+		return m, true
+	case OrderedMap:
+		return convertValue(&v_, index)
+	case map[string]interface{}:
+		var ret map[string]interface{}
+		for k, value := range v_ {
+			converted, ok := convertValue(value, index)
+			if !ok {
+				continue
+			}
+			if ret == nil {
+				ret = make(map[string]interface{}, len(v_))
+				for k_, value_ := range v_ {
+					ret[k_] = value_
+				}
+			}
+			ret[k] = converted
+		}
+		if ret == nil {
+			return v, false
+		}
+		index.record(ret, v)
+		return ret, true
+	case []interface{}:
+		var ret []interface{}
+		for i, value := range v_ {
+			converted, ok := convertValue(value, index)
+			if !ok {
+				continue
+			}
+			if ret == nil {
+				ret = make([]interface{}, len(v_))
+				copy(ret, v_)
+			}
+			ret[i] = converted
+		}
+		if ret == nil {
+			return v, false
+		}
+		index.record(ret, v)
+		return ret, true
+	default:
+		return v, false
+	}
+}
+
+func nodeToValue(node *yaml.Node, index valueIndex) interface{} {
+	if node == nil {
+		return nil
+	}
+	//exhaustive:ignore
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 1 {
+			return nodeToValue(node.Content[0], index)
+		}
+		return nil
+	case yaml.AliasNode:
+		return nodeToValue(node.Alias, index)
+	case yaml.MappingNode:
+		m := make(map[string]interface{}, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			m[node.Content[i].Value] = nodeToValue(node.Content[i+1], index)
+		}
+		index.record(m, node)
+		return m
+	case yaml.SequenceNode:
+		s := make([]interface{}, 0, len(node.Content))
+		for _, item := range node.Content {
+			s = append(s, nodeToValue(item, index))
+		}
+		index.record(s, node)
+		return s
+	case yaml.ScalarNode:
+		var v interface{}
+		if err := node.Decode(&v); err != nil {
+			v = node.Value
+		}
+		if index != nil && !isPlainScalar(node, v) {
+			return node
+		}
+		return v
+	default:
+		return nil
+	}
+}
+
+// isPlainScalar returns true if v, decoded from node, represents it exactly.
+func isPlainScalar(node *yaml.Node, v interface{}) bool {
+	switch node.Tag {
+	case "!!str", "!!bool", "!!null", "!!float":
+		return true
+	case "!!int":
+		_, ok := v.(int)
+		return ok
+	default:
+		return false
+	}
+}
+
+// resultValue returns the value of a lookup result, or the value it was
+// converted from.
+func resultValue(v reflect.Value, index valueIndex) interface{} {
+	ret := v.Interface()
+	rv := reflect.ValueOf(ret)
+	//exhaustive:ignore
+	switch rv.Kind() {
+	case reflect.Map, reflect.Slice:
+		if original, ok := index[rv.Pointer()]; ok {
+			return original
+		}
+	}
+	return ret
+}
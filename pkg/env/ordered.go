@@ -0,0 +1,138 @@
+package env
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// OrderedMap is a map with string keys remembering the order in which keys
+// were inserted. It is used for mappings converted from YAML, so that they
+// are rendered back in their original order.
+//
+// OrderedMap values can be stored as variables: jsonpath lookups see them as
+// plain maps, and they are converted to map[string]interface{} when passed to
+// Go templates (see NodeToValue).
+type OrderedMap struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// NewOrderedMap returns an empty OrderedMap.
+func NewOrderedMap() *OrderedMap {
+	return &OrderedMap{values: map[string]interface{}{}}
+}
+
+// Set sets the value of key. New keys are added at the end, existing keys
+// keep their position.
+func (m *OrderedMap) Set(key string, value interface{}) {
+	if m.values == nil {
+		m.values = map[string]interface{}{}
+	}
+	if _, ok := m.values[key]; !ok {
+		m.keys = append(m.keys, key)
+	}
+	m.values[key] = value
+}
+
+// Get returns the value of key, and whether it is present.
+func (m *OrderedMap) Get(key string) (interface{}, bool) {
+	v, ok := m.values[key]
+	return v, ok
+}
+
+// Delete removes key, keeping the order of the remaining keys.
+func (m *OrderedMap) Delete(key string) {
+	if _, ok := m.values[key]; !ok {
+		return
+	}
+	delete(m.values, key)
+	for i, k := range m.keys {
+		if k == key {
+			m.keys = append(m.keys[:i:i], m.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// Keys returns the keys in insertion order. The slice must not be modified.
+func (m *OrderedMap) Keys() []string {
+	return m.keys
+}
+
+func (m *OrderedMap) Len() int {
+	return len(m.keys)
+}
+
+// ToMap returns the entries as a plain map. Values are not converted.
+func (m *OrderedMap) ToMap() map[string]interface{} {
+	ret := make(map[string]interface{}, len(m.keys))
+	for k, v := range m.values {
+		ret[k] = v
+	}
+	return ret
+}
+
+// String formats the map like fmt formats plain maps, in insertion order.
+func (m *OrderedMap) String() string {
+	var sb strings.Builder
+	sb.WriteString("map[")
+	for i, k := range m.keys {
+		if i > 0 {
+			sb.WriteString(" ")
+		}
+		fmt.Fprintf(&sb, "%s:%v", k, m.values[k])
+	}
+	sb.WriteString("]")
+	return sb.String()
+}
+
+// MarshalJSON encodes the map as a JSON object, in insertion order.
+func (m *OrderedMap) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	// encoding/json escapes HTML in the output of Marshalers itself, if
+	// configured to
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+
+	buf.WriteString("{")
+	for i, k := range m.keys {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		if err := encoder.Encode(k); err != nil {
+			return nil, err
+		}
+		buf.WriteString(":")
+		if err := encoder.Encode(m.values[k]); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteString("}")
+
+	// Encode terminates each value with a newline
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, buf.Bytes()); err != nil {
+		return nil, err
+	}
+	return compacted.Bytes(), nil
+}
+
+// MarshalYAML encodes the map as a YAML mapping, in insertion order.
+func (m *OrderedMap) MarshalYAML() (interface{}, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, k := range m.keys {
+		key, value := &yaml.Node{}, &yaml.Node{}
+		if err := key.Encode(k); err != nil {
+			return nil, err
+		}
+		if err := value.Encode(m.values[k]); err != nil {
+			return nil, err
+		}
+		node.Content = append(node.Content, key, value)
+	}
+	return node, nil
+}
@@ -0,0 +1,94 @@
+package env
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func newTestOrderedMap() *OrderedMap {
+	m := NewOrderedMap()
+	m.Set("zeta", 1)
+	m.Set("alpha", "<a&b>")
+	nested := NewOrderedMap()
+	nested.Set("y", true)
+	nested.Set("x", nil)
+	m.Set("nested", nested)
+	return m
+}
+
+func TestOrderedMap(t *testing.T) {
+	m := newTestOrderedMap()
+	assert.Equal(t, []string{"zeta", "alpha", "nested"}, m.Keys())
+	assert.Equal(t, 3, m.Len())
+
+	// updating a key keeps its position
+	m.Set("zeta", 2)
+	v, ok := m.Get("zeta")
+	assert.True(t, ok)
+	assert.Equal(t, 2, v)
+	assert.Equal(t, []string{"zeta", "alpha", "nested"}, m.Keys())
+
+	m.Delete("alpha")
+	m.Delete("missing")
+	_, ok = m.Get("alpha")
+	assert.False(t, ok)
+	assert.Equal(t, []string{"zeta", "nested"}, m.Keys())
+
+	m.Set("alpha", 3)
+	assert.Equal(t, []string{"zeta", "nested", "alpha"}, m.Keys())
+	assert.Equal(t, "map[zeta:2 nested:map[y:true x:<nil>] alpha:3]", m.String())
+}
+
+func TestOrderedMapMarshal(t *testing.T) {
+	m := newTestOrderedMap()
+
+	b, err := json.Marshal(m)
+	require.NoError(t, err)
+	assert.Equal(t, `{"zeta":1,"alpha":"\u003ca\u0026b\u003e","nested":{"y":true,"x":null}}`, string(b))
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	require.NoError(t, encoder.Encode(m))
+	assert.Equal(t, `{"zeta":1,"alpha":"<a&b>","nested":{"y":true,"x":null}}`+"\n", buf.String())
+
+	b, err = yaml.Marshal(m)
+	require.NoError(t, err)
+	assert.Equal(t, "zeta: 1\nalpha: <a&b>\nnested:\n    \"y\": true\n    x: null\n", string(b))
+}
+
+func TestOrderedMapVariables(t *testing.T) {
+	m := newTestOrderedMap()
+	env := NewEnv(WithVars(map[string]interface{}{
+		"m":     m,
+		"plain": map[string]interface{}{"list": []interface{}{m}},
+	}))
+
+	v, err := env.LookupFirst("$.m.nested")
+	require.NoError(t, err)
+	nested, _ := m.Get("nested")
+	assert.Same(t, nested, v)
+
+	v, err = env.LookupFirst("$.plain.list[0].zeta")
+	require.NoError(t, err)
+	assert.Equal(t, 1, v)
+
+	v, err = env.LookupFirst("$.plain.list[0]")
+	require.NoError(t, err)
+	assert.Same(t, m, v)
+
+	assert.Equal(t, map[string]interface{}{
+		"list": []interface{}{
+			map[string]interface{}{
+				"zeta":   1,
+				"alpha":  "<a&b>",
+				"nested": map[string]interface{}{"y": true, "x": nil},
+			},
+		},
+	}, NodeToValue(map[string]interface{}{"list": []interface{}{m}}))
+}
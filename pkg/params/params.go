@@ -0,0 +1,173 @@
+// Package params exposes the parameters templates declare with !Params as
+// glazed parameter definitions, so that they can be set with typed command
+// line flags.
+package params
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-go-golems/glazed/pkg/cmds/parameters"
+	"github.com/go-go-golems/go-emrichen/pkg/emrichen"
+	"github.com/go-go-golems/go-emrichen/pkg/env"
+	"gopkg.in/yaml.v3"
+)
+
+// ParameterDefinitions returns a glazed flag definition for each template
+// parameter. Parameters with an enum of strings become choices, lists become
+// string lists and maps key-value flags. Defaults are only carried over if
+// they don't contain tags, since they would have to be evaluated.
+func ParameterDefinitions(params []emrichen.Param) []*parameters.ParameterDefinition {
+	ret := make([]*parameters.ParameterDefinition, 0, len(params))
+	for _, param := range params {
+		parameterType := parameterTypes[param.Type]
+		options := []parameters.ParameterDefinitionOption{
+			parameters.WithHelp(help(param)),
+			parameters.WithRequired(param.Required && param.Default == nil),
+		}
+
+		if choices, ok := stringChoices(param); ok {
+			parameterType = parameters.ParameterTypeChoice
+			options = append(options, parameters.WithChoices(choices...))
+		}
+		if param.Default != nil && !hasTags(param.Default) {
+			if v, ok := defaultValue(parameterType, param.Default); ok {
+				options = append(options, parameters.WithDefault(v))
+			}
+		}
+
+		ret = append(ret, parameters.NewParameterDefinition(param.Name, parameterType, options...))
+	}
+	return ret
+}
+
+// Vars returns the values of the parameters parsed by glazed, to be passed
+// to the interpreter as variables. Parameters without a value, or only set to
+// the default of their flag, are left out, so that the defaults of the
+// template apply with their original types.
+func Vars(parsed *parameters.ParsedParameters, params []emrichen.Param) map[string]interface{} {
+	ret := map[string]interface{}{}
+	for _, param := range params {
+		p, ok := parsed.Get(param.Name)
+		if !ok || p.Value == nil || isDefault(p) {
+			continue
+		}
+		switch v := p.Value.(type) {
+		case []string:
+			list := make([]interface{}, len(v))
+			for i, s := range v {
+				list[i] = s
+			}
+			ret[param.Name] = list
+		case map[string]string:
+			m := make(map[string]interface{}, len(v))
+			for k, s := range v {
+				m[k] = s
+			}
+			ret[param.Name] = m
+		default:
+			ret[param.Name] = v
+		}
+	}
+	return ret
+}
+
+// isDefault returns true if p was only set from the default of its definition.
+func isDefault(p *parameters.ParsedParameter) bool {
+	if len(p.Log) == 0 {
+		return false
+	}
+	for _, step := range p.Log {
+		// ParseParameter records defaults as "default"
+		if step.Source != parameters.SourceDefaults && step.Source != "default" {
+			return false
+		}
+	}
+	return true
+}
+
+var parameterTypes = map[emrichen.ParamType]parameters.ParameterType{
+	emrichen.ParamTypeAny:    parameters.ParameterTypeString,
+	emrichen.ParamTypeString: parameters.ParameterTypeString,
+	emrichen.ParamTypeInt:    parameters.ParameterTypeInteger,
+	emrichen.ParamTypeFloat:  parameters.ParameterTypeFloat,
+	emrichen.ParamTypeBool:   parameters.ParameterTypeBool,
+	emrichen.ParamTypeList:   parameters.ParameterTypeStringList,
+	emrichen.ParamTypeMap:    parameters.ParameterTypeKeyValue,
+}
+
+func help(param emrichen.Param) string {
+	ret := param.Description
+	if ret == "" {
+		ret = fmt.Sprintf("Template parameter %s (%s)", param.Name, param.Type)
+	}
+	return ret
+}
+
+// stringChoices returns the enum of a string parameter.
+func stringChoices(param emrichen.Param) ([]string, bool) {
+	if len(param.Enum) == 0 || (param.Type != emrichen.ParamTypeString && param.Type != emrichen.ParamTypeAny) {
+		return nil, false
+	}
+	choices := make([]string, 0, len(param.Enum))
+	for _, e := range param.Enum {
+		if e.Kind != yaml.ScalarNode {
+			return nil, false
+		}
+		choices = append(choices, e.Value)
+	}
+	return choices, true
+}
+
+// hasTags returns true if node contains custom tags.
+func hasTags(node *yaml.Node) bool {
+	if strings.HasPrefix(node.Tag, "!") && !strings.HasPrefix(node.Tag, "!!") {
+		return true
+	}
+	for _, child := range node.Content {
+		if hasTags(child) {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultValue converts a default to the type of value glazed expects for
+// parameterType.
+func defaultValue(parameterType parameters.ParameterType, node *yaml.Node) (interface{}, bool) {
+	v := env.NodeToValue(node)
+	//exhaustive:ignore
+	switch parameterType {
+	case parameters.ParameterTypeString, parameters.ParameterTypeChoice:
+		if v == nil || node.Kind != yaml.ScalarNode {
+			return nil, false
+		}
+		return fmt.Sprint(v), true
+	case parameters.ParameterTypeInteger:
+		i, ok := v.(int)
+		return i, ok
+	case parameters.ParameterTypeFloat:
+		switch f := v.(type) {
+		case float64:
+			return f, true
+		case int:
+			return float64(f), true
+		}
+		return nil, false
+	case parameters.ParameterTypeBool:
+		b, ok := v.(bool)
+		return b, ok
+	case parameters.ParameterTypeStringList:
+		list, ok := v.([]interface{})
+		if !ok {
+			return nil, false
+		}
+		ret := make([]string, len(list))
+		for i, item := range list {
+			ret[i] = fmt.Sprint(item)
+		}
+		return ret, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,139 @@
+package params
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-go-golems/glazed/pkg/cmds/parameters"
+	"github.com/go-go-golems/go-emrichen/pkg/emrichen"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+const template = `!Params
+env:
+  type: string
+  enum: [dev, prod]
+  required: true
+  description: Target environment
+replicas:
+  type: int
+  default: 2
+tags:
+  type: list
+  default: [a, b]
+debug: bool
+name:
+  type: string
+  default: !Format "app-{env}"
+---
+name: !Var name
+`
+
+func compile(t *testing.T) []emrichen.Param {
+	tmpl, err := emrichen.Compile(strings.NewReader(template))
+	require.NoError(t, err)
+	return tmpl.Params()
+}
+
+func TestParameterDefinitions(t *testing.T) {
+	defs := ParameterDefinitions(compile(t))
+	require.Len(t, defs, 5)
+
+	byName := map[string]*parameters.ParameterDefinition{}
+	for _, def := range defs {
+		byName[def.Name] = def
+	}
+
+	assert.Equal(t, parameters.ParameterTypeChoice, byName["env"].Type)
+	assert.Equal(t, []string{"dev", "prod"}, byName["env"].Choices)
+	assert.True(t, byName["env"].Required)
+	assert.Equal(t, "Target environment", byName["env"].Help)
+
+	assert.Equal(t, parameters.ParameterTypeInteger, byName["replicas"].Type)
+	require.NotNil(t, byName["replicas"].Default)
+	assert.Equal(t, 2, *byName["replicas"].Default)
+
+	assert.Equal(t, parameters.ParameterTypeStringList, byName["tags"].Type)
+	require.NotNil(t, byName["tags"].Default)
+	assert.Equal(t, []string{"a", "b"}, *byName["tags"].Default)
+
+	assert.Equal(t, parameters.ParameterTypeBool, byName["debug"].Type)
+	assert.Nil(t, byName["debug"].Default)
+
+	// defaults using tags are left to the template
+	assert.Equal(t, parameters.ParameterTypeString, byName["name"].Type)
+	assert.Nil(t, byName["name"].Default)
+}
+
+func TestVars(t *testing.T) {
+	params := compile(t)
+	defs := ParameterDefinitions(params)
+
+	parsed := parameters.NewParsedParameters()
+	for _, def := range defs {
+		var args []string
+		switch def.Name {
+		case "env":
+			args = []string{"prod"}
+		case "tags":
+			args = []string{"x", "y"}
+		default:
+			continue
+		}
+		p, err := def.ParseParameter(args)
+		require.NoError(t, err)
+		parsed.Set(def.Name, p)
+	}
+
+	vars := Vars(parsed, params)
+	assert.Equal(t, map[string]interface{}{
+		"env":  "prod",
+		"tags": []interface{}{"x", "y"},
+	}, vars)
+
+	tmpl, err := emrichen.Compile(strings.NewReader(template))
+	require.NoError(t, err)
+	documents, err := tmpl.Render(vars)
+	require.NoError(t, err)
+	out, err := yaml.Marshal(documents[0])
+	require.NoError(t, err)
+	assert.Equal(t, "name: app-prod\n", string(out))
+}
+
+func TestVarsLeavesOutDefaults(t *testing.T) {
+	tmpl, err := emrichen.Compile(strings.NewReader(`!Params
+ports:
+  type: list
+  default: [80, 443]
+replicas:
+  type: int
+  default: 2
+---
+ports: !Var ports
+replicas: !Var replicas
+`))
+	require.NoError(t, err)
+	params := tmpl.Params()
+	defs := parameters.NewParameterDefinitions(parameters.WithParameterDefinitionList(ParameterDefinitions(params)))
+
+	parsed, err := defs.ParsedParametersFromDefaults()
+	require.NoError(t, err)
+	// flags left unset by ParseParameter get the default too
+	def, ok := defs.Get("replicas")
+	require.True(t, ok)
+	p, err := def.ParseParameter(nil)
+	require.NoError(t, err)
+	parsed.Set("replicas", p)
+
+	vars := Vars(parsed, params)
+	assert.Empty(t, vars)
+
+	// the defaults of the template keep their types
+	documents, err := tmpl.Render(vars)
+	require.NoError(t, err)
+	out, err := yaml.Marshal(documents[0])
+	require.NoError(t, err)
+	assert.Equal(t, "ports:\n    - 80\n    - 443\nreplicas: 2\n", string(out))
+}